@@ -0,0 +1,22 @@
+-- Bans of retrologin --bans with the PostgreSQL storage, next to the dofus and
+-- retro schemas. A NULL until is a permanent ban. Changing a ban must also set
+-- created to now(), so that the accounts logged in are kicked.
+
+CREATE SCHEMA IF NOT EXISTS retrologin;
+
+CREATE TABLE IF NOT EXISTS retrologin.account_bans (
+    account_id uuid PRIMARY KEY REFERENCES dofus.accounts (id) ON DELETE CASCADE,
+    until      timestamp with time zone,
+    reason     text DEFAULT ''::text NOT NULL,
+    created    timestamp with time zone DEFAULT now() NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS account_bans_created_idx ON retrologin.account_bans (created);
+
+-- IPv4 addresses, and IPv6 /64 networks in CIDR notation, like 2001:db8::/64.
+CREATE TABLE IF NOT EXISTS retrologin.ip_bans (
+    ip      text PRIMARY KEY,
+    until   timestamp with time zone,
+    reason  text DEFAULT ''::text NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL
+);
@@ -0,0 +1,240 @@
+package retrologin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginAttempts are the consecutive failed logins recorded for a key.
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// LoginAttemptStore records failed logins by key, which is the IP address of the
+// client, the account name, or the account name along with the IP address.
+// Implementations can share the records between instances of the server.
+type LoginAttemptStore interface {
+	LoginAttempts(ctx context.Context, key string) (LoginAttempts, error)
+	AddFailedLogin(ctx context.Context, key string, t time.Time) (LoginAttempts, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// MemoryLoginAttemptStore is a LoginAttemptStore that keeps the records in
+// memory, forgetting those whose last failure is older than its TTL.
+type MemoryLoginAttemptStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]LoginAttempts
+	pruned   time.Time
+}
+
+func NewMemoryLoginAttemptStore(ttl time.Duration) *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		ttl:      ttl,
+		attempts: make(map[string]LoginAttempts),
+	}
+}
+
+func (s *MemoryLoginAttemptStore) LoginAttempts(ctx context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok || time.Since(attempts.LastFailure) > s.ttl {
+		return LoginAttempts{}, nil
+	}
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) AddFailedLogin(ctx context.Context, key string, t time.Time) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.Sub(s.pruned) > s.ttl {
+		for k, v := range s.attempts {
+			if t.Sub(v.LastFailure) > s.ttl {
+				delete(s.attempts, k)
+			}
+		}
+		s.pruned = t
+	}
+
+	attempts := s.attempts[key]
+	if t.Sub(attempts.LastFailure) > s.ttl {
+		attempts = LoginAttempts{}
+	}
+	attempts.Failures++
+	attempts.LastFailure = t
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// lockoutPolicy locks a key out once it has more than maxFailures failed logins,
+// for a duration that starts at dur and doubles with every further failure, up
+// to maxDur.
+type lockoutPolicy struct {
+	maxFailures int
+	dur         time.Duration
+	maxDur      time.Duration
+}
+
+func (p lockoutPolicy) lockedUntil(attempts LoginAttempts) time.Time {
+	excess := attempts.Failures - p.maxFailures
+	if excess <= 0 {
+		return time.Time{}
+	}
+
+	d := p.dur
+	for i := 1; i < excess && d < p.maxDur; i++ {
+		d *= 2
+	}
+	if d > p.maxDur {
+		d = p.maxDur
+	}
+
+	return attempts.LastFailure.Add(d)
+}
+
+// lockout returns how long the client at ip, or the account name from ip, is
+// still locked out for. Failures of an account are counted per IP address, so
+// that nobody can lock its owner out.
+func (s *Server) lockout(ctx context.Context, ip, name string) (time.Duration, error) {
+	var remaining time.Duration
+
+	for _, check := range s.attemptsChecks(ip, name) {
+		attempts, err := s.loginAttempts.LoginAttempts(ctx, check.key)
+		if err != nil {
+			return 0, err
+		}
+		d := time.Until(check.policy.lockedUntil(attempts))
+		if d > remaining {
+			remaining = d
+		}
+	}
+
+	return remaining, nil
+}
+
+// loginDelay returns how long a login of the account name is still delayed
+// for. Failures of an account from every IP address count towards it, but they
+// only slow its logins down instead of locking it out.
+func (s *Server) loginDelay(ctx context.Context, name string) (time.Duration, error) {
+	attempts, err := s.loginAttempts.LoginAttempts(ctx, accountAttemptsKey(name))
+	if err != nil {
+		return 0, err
+	}
+	d := time.Until(s.accountDelay.lockedUntil(attempts))
+	if d < 0 {
+		return 0, nil
+	}
+	return d, nil
+}
+
+func (s *Server) addFailedLogin(ctx context.Context, ip, name string) error {
+	now := time.Now().UTC()
+
+	keys := []string{accountAttemptsKey(name)}
+	for _, check := range s.attemptsChecks(ip, name) {
+		keys = append(keys, check.key)
+	}
+	for _, key := range keys {
+		attempts, err := s.loginAttempts.AddFailedLogin(ctx, key, now)
+		if err != nil {
+			return err
+		}
+		s.logger.Debugw("failed login",
+			"key", key,
+			"failures", attempts.Failures,
+		)
+	}
+
+	return nil
+}
+
+func (s *Server) resetFailedLogins(ctx context.Context, ip, name string) error {
+	for _, key := range []string{accountAttemptsKey(name), accountIPAttemptsKey(name, ip)} {
+		err := s.loginAttempts.ResetLoginAttempts(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type attemptsCheck struct {
+	key    string
+	policy lockoutPolicy
+}
+
+// attemptsChecks returns the keys under which failed logins of name from ip are
+// counted, with their lockout policies. Clients without an IP address, like
+// those of a net.Pipe, are exempt from the per-IP lockout.
+func (s *Server) attemptsChecks(ip, name string) []attemptsCheck {
+	checks := []attemptsCheck{{key: accountIPAttemptsKey(name, ip), policy: s.accountLockout}}
+	if net.ParseIP(ip) != nil {
+		checks = append(checks, attemptsCheck{key: ipAttemptsKey(ip), policy: s.ipLockout})
+	}
+	return checks
+}
+
+func ipAttemptsKey(ip string) string {
+	return "ip:" + attemptsIP(ip)
+}
+
+func accountAttemptsKey(name string) string {
+	return "account:" + strings.ToLower(name)
+}
+
+func accountIPAttemptsKey(name, ip string) string {
+	return accountAttemptsKey(name) + "@" + attemptsIP(ip)
+}
+
+func attemptsIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return ipKey(parsed)
+	}
+	return ip
+}
+
+// addrIP returns the IP address of addr, or nil if it has none, like the
+// addresses of in-memory connections.
+func addrIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func clientIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// remainingTimeExtra formats d as the days, hours and minutes shown by the client
+// along with a temporary ban.
+func remainingTimeExtra(d time.Duration) string {
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("%d|%d|%d", minutes/(24*60), minutes/60%24, minutes%60)
+}
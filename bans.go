@@ -0,0 +1,238 @@
+package retrologin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+// Ban keeps an account or an IP address from logging in.
+type Ban struct {
+	// Until is when the ban is lifted. The zero time means a permanent ban, any
+	// other a suspension.
+	Until  time.Time
+	Reason string
+}
+
+// active reports whether the ban is still in effect at t.
+func (b Ban) active(t time.Time) bool {
+	return b.Until.IsZero() || t.Before(b.Until)
+}
+
+// loginError returns the login error that tells the client about the ban at t.
+func (b Ban) loginError(t time.Time) msgsvr.AccountLoginError {
+	if b.Until.IsZero() {
+		return msgsvr.AccountLoginError{Reason: enum.AccountLoginErrorReason.Banned}
+	}
+	return msgsvr.AccountLoginError{
+		Reason: enum.AccountLoginErrorReason.Kicked,
+		Extra:  remainingTimeExtra(b.Until.Sub(t)),
+	}
+}
+
+// BanStore looks up bans. Implementations can keep the ban state of an account
+// on the account itself, on its user or in a table of their own.
+type BanStore interface {
+	AccountBan(ctx context.Context, account dofus.Account, user dofus.User) (ban Ban, ok bool, err error)
+	// AccountBansSince returns, by account ID, the bans of accounts that were
+	// made or changed at or after since.
+	AccountBansSince(ctx context.Context, since time.Time) (map[string]Ban, error)
+	// IPBan returns the ban of an IP address, keyed as by IPBanKey.
+	IPBan(ctx context.Context, ip string) (ban Ban, ok bool, err error)
+}
+
+// IPBanKey returns the key of the bans of ip: the address for IPv4, and its /64
+// network in CIDR notation for IPv6, like 2001:db8::/64, as a single subscriber
+// is usually given a whole /64.
+func IPBanKey(ip net.IP) string {
+	return ipKey(ip)
+}
+
+// MemoryBanStore is a BanStore that keeps bans in memory, by account ID and by
+// IP address.
+type MemoryBanStore struct {
+	mu       sync.Mutex
+	accounts map[string]memoryAccountBan
+	ips      map[string]Ban
+}
+
+type memoryAccountBan struct {
+	Ban
+	at time.Time
+}
+
+func NewMemoryBanStore() *MemoryBanStore {
+	return &MemoryBanStore{
+		accounts: make(map[string]memoryAccountBan),
+		ips:      make(map[string]Ban),
+	}
+}
+
+func (s *MemoryBanStore) AccountBan(ctx context.Context, account dofus.Account, user dofus.User) (Ban, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ban, ok := s.accounts[account.Id]
+	return ban.Ban, ok, nil
+}
+
+func (s *MemoryBanStore) AccountBansSince(ctx context.Context, since time.Time) (map[string]Ban, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bans := make(map[string]Ban)
+	for accountId, ban := range s.accounts {
+		if !ban.at.Before(since) {
+			bans[accountId] = ban.Ban
+		}
+	}
+	return bans, nil
+}
+
+func (s *MemoryBanStore) IPBan(ctx context.Context, ip string) (Ban, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ban, ok := s.ips[ip]
+	return ban, ok, nil
+}
+
+func (s *MemoryBanStore) BanAccount(accountId string, ban Ban) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts[accountId] = memoryAccountBan{Ban: ban, at: time.Now()}
+}
+
+func (s *MemoryBanStore) UnbanAccount(accountId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.accounts, accountId)
+}
+
+// BanIP bans an IPv4 address or, for an IPv6 address, its /64 network.
+func (s *MemoryBanStore) BanIP(ip net.IP, ban Ban) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ips[IPBanKey(ip)] = ban
+}
+
+func (s *MemoryBanStore) UnbanIP(ip net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ips, IPBanKey(ip))
+}
+
+// ipBan returns the ban in effect on ip, if any. Clients without an IP address,
+// like those of a net.Pipe, can't be banned by IP.
+func (s *Server) ipBan(ctx context.Context, ip net.IP) (Ban, bool, error) {
+	if s.bans == nil || ip == nil {
+		return Ban{}, false, nil
+	}
+	ban, ok, err := s.bans.IPBan(ctx, IPBanKey(ip))
+	if err != nil || !ok {
+		return Ban{}, false, err
+	}
+	return ban, ban.active(time.Now()), nil
+}
+
+// accountBan returns the ban in effect on account, if any.
+func (s *Server) accountBan(ctx context.Context, account dofus.Account, user dofus.User) (Ban, bool, error) {
+	if s.bans == nil {
+		return Ban{}, false, nil
+	}
+	ban, ok, err := s.bans.AccountBan(ctx, account, user)
+	if err != nil || !ok {
+		return Ban{}, false, err
+	}
+	return ban, ban.active(time.Now()), nil
+}
+
+// watchBans kicks the sessions of the accounts that got banned since they logged
+// in, and revokes the tickets they have not redeemed yet.
+func (s *Server) watchBans(ctx context.Context, d time.Duration) error {
+	if s.bans == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	since := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			// Polls overlap by d, so that bans recorded by a clock a little behind
+			// ours aren't missed.
+			err := s.kickBannedAccounts(ctx, since.Add(-d))
+			if err != nil {
+				s.logger.Errorw(fmt.Errorf("could not kick banned accounts: %w", err).Error())
+				continue
+			}
+			since = now
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Server) kickBannedAccounts(ctx context.Context, since time.Time) error {
+	bans, err := s.bans.AccountBansSince(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for accountId, ban := range bans {
+		if !ban.active(now) {
+			continue
+		}
+
+		s.kickAccount(accountId, ban)
+		err := s.storage.DeleteTicketByAccountId(ctx, accountId)
+		if err != nil {
+			if errors.Is(err, retro.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		s.logger.Infow("revoked ticket of banned account",
+			"account_id", accountId,
+		)
+	}
+
+	return nil
+}
+
+// kickAccount tells the sessions logged in to an account about its ban and
+// closes them.
+func (s *Server) kickAccount(accountId string, ban Ban) {
+	var kicked []*session
+	s.mu.Lock()
+	for sess := range s.sessionsByAccountId[accountId] {
+		kicked = append(kicked, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range kicked {
+		sess.disconnect(ban.loginError(time.Now()))
+		s.logger.Infow("kicked banned account",
+			"account_id", accountId,
+			"client_address", sess.conn.RemoteAddr().String(),
+			"until", ban.Until,
+			"reason", ban.Reason,
+		)
+	}
+}
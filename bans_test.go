@@ -0,0 +1,81 @@
+package retrologin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/retro"
+)
+
+func TestKickBannedAccounts(t *testing.T) {
+	svr := newLoginTestServer(t)
+	bans := NewMemoryBanStore()
+	svr.bans = bans
+	ctx := context.Background()
+
+	conn, client := net.Pipe()
+	defer client.Close()
+	sess := &session{svr: svr, conn: conn}
+	err := svr.controlAccount("1", sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticketId, err := svr.storage.CreateTicket(ctx, retro.Ticket{AccountId: "1", GameServerId: 601})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bans.BanAccount("2", Ban{})
+	since := time.Now()
+	bans.BanAccount("1", Ban{Reason: "cheating"})
+
+	received := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(client)
+		received <- string(b)
+	}()
+
+	err = svr.kickBannedAccounts(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-received; !strings.HasPrefix(got, "AlEb") {
+		t.Errorf("received %q, want the banned login error", got)
+	}
+	_, err = svr.storage.(*MemoryStorage).UseTicket(ctx, ticketId)
+	if !errors.Is(err, retro.ErrNotFound) {
+		t.Errorf("UseTicket of the banned account: err = %v, want %v", err, retro.ErrNotFound)
+	}
+}
+
+func TestIPBanNetwork(t *testing.T) {
+	svr := newLoginTestServer(t)
+	bans := NewMemoryBanStore()
+	svr.bans = bans
+	bans.BanIP(net.ParseIP("2001:db8::1"), Ban{})
+
+	tests := []struct {
+		ip     net.IP
+		banned bool
+	}{
+		{net.ParseIP("2001:db8::ffff"), true},
+		{net.ParseIP("2001:db8:0:1::1"), false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		_, banned, err := svr.ipBan(context.Background(), test.ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if banned != test.banned {
+			t.Errorf("ipBan(%v) = %t, want %t", test.ip, banned, test.banned)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+// Package client is the client side of the login protocol of Dofus Retro, for
+// bots, load tests and monitoring probes.
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroproto"
+	"github.com/kralamoure/retroproto/msgcli"
+	"github.com/kralamoure/retroproto/msgsvr"
+	prototyp "github.com/kralamoure/retroproto/typ"
+
+	"github.com/kralamoure/retrologin/credential"
+)
+
+// DefaultVersion is the version of the client sent by default.
+var DefaultVersion = msgcli.AccountVersion{Major: 1, Minor: 29, Patch: 1}
+
+// ErrClosed is returned when the server closes the connection without saying why.
+var ErrClosed = errors.New("connection closed by the server")
+
+type Config struct {
+	// Version is the version of the client sent to the server. DefaultVersion is
+	// used if it is zero.
+	Version msgcli.AccountVersion
+	// QueueUpdate, if not nil, is called with each position in the login queue
+	// sent by the server while logging in.
+	QueueUpdate func(QueuePosition)
+}
+
+// Client is a connection to a login server. Its methods must not be called
+// concurrently.
+type Client struct {
+	conn        net.Conn
+	rd          *bufio.Reader
+	salt        string
+	version     msgcli.AccountVersion
+	queueUpdate func(QueuePosition)
+}
+
+// Account is what the server sends about the account once logged in.
+type Account struct {
+	Nickname       string
+	Community      dofustyp.Community
+	SecretQuestion string
+	Hosts          []Host
+	// Admin reports whether the account is allowed to use the admin console.
+	Admin bool
+}
+
+// Host is a game server, as listed in AccountHosts.
+type Host struct {
+	Id         int
+	State      retrotyp.GameServerState
+	Completion int
+	CanLog     bool
+}
+
+// QueuePosition is a position in the login queue.
+type QueuePosition struct {
+	Position       int
+	Subscribers    int
+	NonSubscribers int
+	Subscriber     bool
+	QueueId        int
+}
+
+// ServersList is the answer to AccountGetServersList.
+type ServersList struct {
+	Subscription time.Time
+	// Characters is the number of characters of the account by game server ID.
+	Characters map[int]int
+}
+
+// Ticket lets an account into a game server.
+type Ticket struct {
+	Id   string
+	Host string
+	Port string
+}
+
+// Addr returns the address of the game server, in the form "host:port".
+func (t Ticket) Addr() string {
+	return net.JoinHostPort(t.Host, t.Port)
+}
+
+// Dial connects to the login server at addr, over TCP.
+func Dial(ctx context.Context, addr string, c Config) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(ctx, conn, c)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewClient starts the protocol on conn, reading the salt that the server sends
+// first. The client owns conn from then on.
+func NewClient(ctx context.Context, conn net.Conn, c Config) (*Client, error) {
+	if c.Version == (msgcli.AccountVersion{}) {
+		c.Version = DefaultVersion
+	}
+
+	client := &Client{
+		conn:        conn,
+		rd:          bufio.NewReader(conn),
+		version:     c.Version,
+		queueUpdate: c.QueueUpdate,
+	}
+
+	stop := client.watch(ctx)
+	defer stop()
+
+	for {
+		id, extra, err := client.receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if id != retroproto.AksHelloConnect {
+			continue
+		}
+
+		var hello msgsvr.AksHelloConnect
+		err = hello.Deserialize(extra)
+		if err != nil {
+			return nil, err
+		}
+		client.salt = hello.Salt
+		return client, nil
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Login sends the version and the credential, then waits in the login queue
+// until the server accepts or refuses them. A refusal is returned as a
+// *LoginError, and the server then closes the connection.
+func (c *Client) Login(ctx context.Context, username, password string) (Account, error) {
+	stop := c.watch(ctx)
+	defer stop()
+
+	hash, err := credential.EncryptedPassword(password, c.salt)
+	if err != nil {
+		return Account{}, err
+	}
+
+	err = c.send(ctx, c.version)
+	if err != nil {
+		return Account{}, err
+	}
+	err = c.send(ctx, msgcli.AccountCredential{
+		Username:     username,
+		Hash:         hash,
+		CryptoMethod: credential.CryptoMethodSalt,
+	})
+	if err != nil {
+		return Account{}, err
+	}
+	err = c.send(ctx, msgcli.AccountQueuePosition{})
+	if err != nil {
+		return Account{}, err
+	}
+
+	var account Account
+	for {
+		id, extra, err := c.receive(ctx)
+		if err != nil {
+			return Account{}, err
+		}
+
+		switch id {
+		case retroproto.AccountNewQueue:
+			var msg msgsvr.AccountNewQueue
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Account{}, err
+			}
+			if c.queueUpdate != nil {
+				c.queueUpdate(QueuePosition{
+					Position:       msg.Position,
+					Subscribers:    msg.TotalAbo,
+					NonSubscribers: msg.TotalNonAbo,
+					Subscriber:     msg.Subscriber,
+					QueueId:        msg.QueueId,
+				})
+			}
+		case retroproto.AccountLoginError:
+			var msg msgsvr.AccountLoginError
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Account{}, err
+			}
+			return Account{}, &LoginError{Reason: msg.Reason, Extra: msg.Extra}
+		case retroproto.AccountPseudo:
+			account.Nickname = extra
+		case retroproto.AccountCommunity:
+			var msg msgsvr.AccountCommunity
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Account{}, err
+			}
+			account.Community = dofustyp.Community(msg.Id)
+		case retroproto.AccountSecretQuestion:
+			var msg msgsvr.AccountSecretQuestion
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Account{}, err
+			}
+			account.SecretQuestion = msg.Value
+		case retroproto.AccountHosts:
+			account.Hosts, err = hosts(extra)
+			if err != nil {
+				return Account{}, err
+			}
+		case retroproto.AccountLoginSuccess:
+			var msg msgsvr.AccountLoginSuccess
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Account{}, err
+			}
+			account.Admin = msg.Authorized
+			return account, nil
+		}
+	}
+}
+
+// ServersList returns the subscription of the account and its characters.
+func (c *Client) ServersList(ctx context.Context) (ServersList, error) {
+	stop := c.watch(ctx)
+	defer stop()
+
+	err := c.send(ctx, msgcli.AccountGetServersList{})
+	if err != nil {
+		return ServersList{}, err
+	}
+
+	for {
+		id, extra, err := c.receive(ctx)
+		if err != nil {
+			return ServersList{}, err
+		}
+
+		switch id {
+		case retroproto.AccountServersListError:
+			return ServersList{}, errors.New("could not get servers list")
+		case retroproto.AccountServersListSuccess:
+			var msg msgsvr.AccountServersListSuccess
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return ServersList{}, err
+			}
+			return ServersList{
+				Subscription: msg.Subscription,
+				Characters:   charactersByServer(msg.ServersCharacters),
+			}, nil
+		}
+	}
+}
+
+// SearchForFriend returns the number of characters of the user with nickname by
+// game server ID, which is empty if there is no such user.
+func (c *Client) SearchForFriend(ctx context.Context, nickname string) (map[int]int, error) {
+	stop := c.watch(ctx)
+	defer stop()
+
+	err := c.send(ctx, msgcli.AccountSearchForFriend{Pseudo: nickname})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		id, extra, err := c.receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if id != retroproto.AccountFriendServerList {
+			continue
+		}
+
+		var msg msgsvr.AccountFriendServerList
+		err = msg.Deserialize(extra)
+		if err != nil {
+			return nil, err
+		}
+		return charactersByServer(msg.ServersCharacters), nil
+	}
+}
+
+// SelectServer returns a ticket to the game server. The server closes the
+// connection afterwards.
+func (c *Client) SelectServer(ctx context.Context, gameServerId int) (Ticket, error) {
+	stop := c.watch(ctx)
+	defer stop()
+
+	err := c.send(ctx, msgcli.AccountSetServer{Id: gameServerId})
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	for {
+		id, extra, err := c.receive(ctx)
+		if err != nil {
+			return Ticket{}, err
+		}
+
+		switch id {
+		case retroproto.AccountSelectServerError:
+			var msg msgsvr.AccountSelectServerError
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Ticket{}, err
+			}
+			return Ticket{}, &SelectServerError{Reason: msg.Reason, Extra: msg.Extra}
+		case retroproto.AccountSelectServerPlainSuccess:
+			var msg msgsvr.AccountSelectServerPlainSuccess
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Ticket{}, err
+			}
+			return Ticket{Id: msg.Ticket, Host: msg.Host, Port: msg.Port}, nil
+		case retroproto.AccountSelectServerSuccess:
+			var msg msgsvr.AccountSelectServerSuccess
+			err = msg.Deserialize(extra)
+			if err != nil {
+				return Ticket{}, err
+			}
+			return Ticket{Id: msg.Ticket, Host: msg.Host, Port: msg.Port}, nil
+		}
+	}
+}
+
+// watch makes the operations on the connection follow ctx, until stop is called.
+func (c *Client) watch(ctx context.Context) (stop func()) {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// Unblocks any pending read or write.
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+type msgOut interface {
+	ProtocolId() retroproto.MsgCliId
+	Serialized() (string, error)
+}
+
+func (c *Client) send(ctx context.Context, msg msgOut) error {
+	extra, err := msg.Serialized()
+	if err != nil {
+		return err
+	}
+
+	// The version and the credential have no prefix.
+	pkt := extra
+	switch id := msg.ProtocolId(); id {
+	case retroproto.AccountVersion, retroproto.AccountCredential:
+	default:
+		pkt = string(id) + extra
+	}
+
+	_, err = io.WriteString(c.conn, pkt+"\n\x00")
+	return c.connError(ctx, err)
+}
+
+// receive returns the next message of the server, turning AksServerMessage into
+// a *ServerMessageError.
+func (c *Client) receive(ctx context.Context) (retroproto.MsgSvrId, string, error) {
+	for {
+		pkt, err := c.rd.ReadString('\x00')
+		if err != nil {
+			return "", "", c.connError(ctx, err)
+		}
+		pkt = strings.TrimSuffix(pkt, "\x00")
+
+		id, ok := retroproto.MsgSvrIdByPkt(pkt)
+		if !ok {
+			continue
+		}
+		extra := strings.TrimPrefix(pkt, string(id))
+
+		if id == retroproto.AksServerMessage {
+			return "", "", &ServerMessageError{Value: extra}
+		}
+		return id, extra, nil
+	}
+}
+
+func (c *Client) connError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, io.EOF) {
+		return ErrClosed
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func hosts(extra string) ([]Host, error) {
+	if extra == "" {
+		return nil, nil
+	}
+
+	var msg msgsvr.AccountHosts
+	err := msg.Deserialize(extra)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hosts: %w", err)
+	}
+
+	hosts := make([]Host, len(msg.Value))
+	for i, v := range msg.Value {
+		hosts[i] = Host{
+			Id:         v.Id,
+			State:      retrotyp.GameServerState(v.State),
+			Completion: v.Completion,
+			CanLog:     v.CanLog,
+		}
+	}
+	return hosts, nil
+}
+
+func charactersByServer(sli []prototyp.AccountServersListServerCharacters) map[int]int {
+	characters := make(map[int]int, len(sli))
+	for _, v := range sli {
+		characters[v.Id] = v.Qty
+	}
+	return characters
+}
@@ -0,0 +1,200 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgcli"
+
+	"github.com/kralamoure/retrologin"
+	"github.com/kralamoure/retrologin/client"
+	"github.com/kralamoure/retrologin/retrologintest"
+)
+
+func dial(t *testing.T, addr string, c client.Config) *client.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cl, err := client.Dial(ctx, addr, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func TestClient(t *testing.T) {
+	cl := dial(t, retrologintest.Start(t, retrologin.Config{}).Addr, client.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := cl.Login(ctx, "player", retrologintest.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := client.Account{
+		Nickname:       "Player",
+		Community:      dofustyp.CommunityInternational,
+		SecretQuestion: "question",
+		Hosts:          []client.Host{{Id: retrologintest.GameServerId, State: retrotyp.GameServerStateOnline, CanLog: true}},
+	}
+	if account.Nickname != want.Nickname || account.Community != want.Community ||
+		account.SecretQuestion != want.SecretQuestion || len(account.Hosts) != 1 ||
+		account.Hosts[0] != want.Hosts[0] || account.Admin != want.Admin {
+		t.Errorf("account = %+v, want %+v", account, want)
+	}
+
+	list, err := cl.ServersList(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Characters) != 1 || list.Characters[retrologintest.GameServerId] != 1 {
+		t.Errorf("characters = %v, want 1 on server 601", list.Characters)
+	}
+
+	friend, err := cl.SearchForFriend(ctx, "player")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(friend) != 1 || friend[retrologintest.GameServerId] != 1 {
+		t.Errorf("characters of the friend = %v, want 1 on server 601", friend)
+	}
+
+	ticket, err := cl.SelectServer(ctx, retrologintest.GameServerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Id == "" || ticket.Addr() != "127.0.0.1:5556" {
+		t.Errorf("ticket = %+v, want one to 127.0.0.1:5556", ticket)
+	}
+
+	_, err = cl.ServersList(ctx)
+	if !errors.Is(err, client.ErrClosed) {
+		t.Errorf("after selecting a server: err = %v, want %v", err, client.ErrClosed)
+	}
+}
+
+func TestClientLoginErrors(t *testing.T) {
+	bans := retrologin.NewMemoryBanStore()
+	svr := retrologintest.Start(t, retrologin.Config{Bans: bans, Takeover: retrologin.TakeoverReject})
+	addr, accountId := svr.Addr, svr.AccountId
+
+	t.Run("access denied", func(t *testing.T) {
+		_, err := dial(t, addr, client.Config{}).Login(context.Background(), "player", "password456")
+		if !errors.Is(err, client.ErrAccessDenied) {
+			t.Errorf("err = %v, want %v", err, client.ErrAccessDenied)
+		}
+	})
+
+	t.Run("bad version", func(t *testing.T) {
+		cl := dial(t, addr, client.Config{Version: msgcli.AccountVersion{Major: 1, Minor: 28}})
+		_, err := cl.Login(context.Background(), "player", retrologintest.Password)
+		var loginErr *client.LoginError
+		if !errors.As(err, &loginErr) || !errors.Is(err, client.ErrBadVersion) {
+			t.Fatalf("err = %v, want %v", err, client.ErrBadVersion)
+		}
+		version, ok := loginErr.RequiredVersion()
+		if !ok || version != retrologin.DefaultClientVersions {
+			t.Errorf("required version = %q, %t, want %q", version, ok, retrologin.DefaultClientVersions)
+		}
+	})
+
+	t.Run("banned", func(t *testing.T) {
+		bans.BanAccount(accountId, retrologin.Ban{})
+		defer bans.UnbanAccount(accountId)
+
+		_, err := dial(t, addr, client.Config{}).Login(context.Background(), "player", retrologintest.Password)
+		if !errors.Is(err, client.ErrBanned) {
+			t.Errorf("err = %v, want %v", err, client.ErrBanned)
+		}
+	})
+
+	t.Run("kicked", func(t *testing.T) {
+		bans.BanAccount(accountId, retrologin.Ban{Until: time.Now().Add(90 * time.Minute)})
+		defer bans.UnbanAccount(accountId)
+
+		_, err := dial(t, addr, client.Config{}).Login(context.Background(), "player", retrologintest.Password)
+		var loginErr *client.LoginError
+		if !errors.As(err, &loginErr) || !errors.Is(err, client.ErrKicked) {
+			t.Fatalf("err = %v, want %v", err, client.ErrKicked)
+		}
+		remaining, ok := loginErr.Remaining()
+		if !ok || remaining != 90*time.Minute {
+			t.Errorf("remaining = %s, %t, want %s", remaining, ok, 90*time.Minute)
+		}
+	})
+
+	t.Run("already logged", func(t *testing.T) {
+		_, err := dial(t, addr, client.Config{}).Login(context.Background(), "player", retrologintest.Password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = dial(t, addr, client.Config{}).Login(context.Background(), "player", retrologintest.Password)
+		if !errors.Is(err, client.ErrAlreadyLogged) {
+			t.Errorf("err = %v, want %v", err, client.ErrAlreadyLogged)
+		}
+	})
+}
+
+func TestClientMaintenance(t *testing.T) {
+	addr := retrologintest.Start(t, retrologin.Config{Maintenance: true}).Addr
+
+	_, err := dial(t, addr, client.Config{}).Login(context.Background(), "player", retrologintest.Password)
+	if !errors.Is(err, client.ErrMaintainAccount) {
+		t.Errorf("err = %v, want %v", err, client.ErrMaintainAccount)
+	}
+}
+
+func TestClientServerMessage(t *testing.T) {
+	addr := retrologintest.Start(t, retrologin.Config{MaxConns: 1}).Addr
+	dial(t, addr, client.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Dial(ctx, addr, client.Config{})
+	var msgErr *client.ServerMessageError
+	if !errors.As(err, &msgErr) {
+		t.Fatalf("err = %v, want a *client.ServerMessageError", err)
+	}
+	if msgErr.Value != "012" {
+		t.Errorf("server message = %q, want %q", msgErr.Value, "012")
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	tests := []struct {
+		err       *client.LoginError
+		target    error
+		remaining time.Duration
+		ok        bool
+	}{
+		{&client.LoginError{Reason: enum.AccountLoginErrorReason.Kicked, Extra: "1|2|3"}, client.ErrKicked,
+			26*time.Hour + 3*time.Minute, true},
+		{&client.LoginError{Reason: enum.AccountLoginErrorReason.Kicked, Extra: "0|0|"}, client.ErrKicked, 0, false},
+		{&client.LoginError{Reason: enum.AccountLoginErrorReason.Banned}, client.ErrBanned, 0, false},
+		{&client.LoginError{Reason: enum.AccountLoginErrorReason.ServerFull}, client.ErrServerFull, 0, false},
+	}
+
+	for _, test := range tests {
+		if !errors.Is(test.err, test.target) {
+			t.Errorf("%v does not match %v", test.err, test.target)
+		}
+		if errors.Is(test.err, client.ErrAccessDenied) {
+			t.Errorf("%v matches %v", test.err, client.ErrAccessDenied)
+		}
+		remaining, ok := test.err.Remaining()
+		if remaining != test.remaining || ok != test.ok {
+			t.Errorf("remaining of %v = %s, %t, want %s, %t", test.err, remaining, ok, test.remaining, test.ok)
+		}
+	}
+}
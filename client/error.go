@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kralamoure/retroproto/enum"
+)
+
+// LoginError is an AccountLoginError sent by the server. It matches, with
+// errors.Is, the Err variable of the same reason.
+type LoginError struct {
+	Reason rune
+	Extra  string
+}
+
+// The reasons of AccountLoginError, to be matched with errors.Is.
+var (
+	ErrDefault                   = &LoginError{Reason: enum.AccountLoginErrorReason.Default}
+	ErrConnectNotFinished        = &LoginError{Reason: enum.AccountLoginErrorReason.ConnectNotFinished}
+	ErrAlreadyLogged             = &LoginError{Reason: enum.AccountLoginErrorReason.AlreadyLogged}
+	ErrAlreadyLoggedGameServer   = &LoginError{Reason: enum.AccountLoginErrorReason.AlreadyLoggedGameServer}
+	ErrBadVersion                = &LoginError{Reason: enum.AccountLoginErrorReason.BadVersion}
+	ErrNotPlayer                 = &LoginError{Reason: enum.AccountLoginErrorReason.NotPlayer}
+	ErrBanned                    = &LoginError{Reason: enum.AccountLoginErrorReason.Banned}
+	ErrUDisconnectAccount        = &LoginError{Reason: enum.AccountLoginErrorReason.UDisconnectAccount}
+	ErrKicked                    = &LoginError{Reason: enum.AccountLoginErrorReason.Kicked}
+	ErrServerFull                = &LoginError{Reason: enum.AccountLoginErrorReason.ServerFull}
+	ErrOldAccount                = &LoginError{Reason: enum.AccountLoginErrorReason.OldAccount}
+	ErrOldAccountUseNew          = &LoginError{Reason: enum.AccountLoginErrorReason.OldAccountUseNew}
+	ErrMaintainAccount           = &LoginError{Reason: enum.AccountLoginErrorReason.MaintainAccount}
+	ErrChooseNickname            = &LoginError{Reason: enum.AccountLoginErrorReason.ChooseNickname}
+	ErrChooseNicknameAlreadyUsed = &LoginError{Reason: enum.AccountLoginErrorReason.ChooseNicknameAlreadyUsed}
+	ErrAccessDenied              = &LoginError{Reason: enum.AccountLoginErrorReason.AccessDenied}
+)
+
+var loginErrorDescriptions = map[rune]string{
+	enum.AccountLoginErrorReason.Default:                   "login error",
+	enum.AccountLoginErrorReason.ConnectNotFinished:        "connection not finished",
+	enum.AccountLoginErrorReason.AlreadyLogged:             "account already logged in",
+	enum.AccountLoginErrorReason.AlreadyLoggedGameServer:   "account already logged in a game server",
+	enum.AccountLoginErrorReason.BadVersion:                "bad client version",
+	enum.AccountLoginErrorReason.NotPlayer:                 "not a player",
+	enum.AccountLoginErrorReason.Banned:                    "account banned",
+	enum.AccountLoginErrorReason.UDisconnectAccount:        "account disconnected",
+	enum.AccountLoginErrorReason.Kicked:                    "account kicked",
+	enum.AccountLoginErrorReason.ServerFull:                "server full",
+	enum.AccountLoginErrorReason.OldAccount:                "old account",
+	enum.AccountLoginErrorReason.OldAccountUseNew:          "old account, use the new one",
+	enum.AccountLoginErrorReason.MaintainAccount:           "account under maintenance",
+	enum.AccountLoginErrorReason.ChooseNickname:            "nickname to choose",
+	enum.AccountLoginErrorReason.ChooseNicknameAlreadyUsed: "chosen nickname already used",
+	enum.AccountLoginErrorReason.AccessDenied:              "access denied",
+}
+
+func (e *LoginError) Error() string {
+	description, ok := loginErrorDescriptions[e.Reason]
+	if !ok {
+		description = fmt.Sprintf("login error %q", e.Reason)
+	}
+	if e.Extra == "" {
+		return description
+	}
+	return fmt.Sprintf("%s: %s", description, e.Extra)
+}
+
+func (e *LoginError) Is(target error) bool {
+	t, ok := target.(*LoginError)
+	return ok && t.Reason == e.Reason
+}
+
+// RequiredVersion returns the version required by the server, for ErrBadVersion.
+func (e *LoginError) RequiredVersion() (string, bool) {
+	if e.Reason != enum.AccountLoginErrorReason.BadVersion {
+		return "", false
+	}
+	return e.Extra, true
+}
+
+// Remaining returns how long is left before logging in is allowed again, for a
+// temporary ErrKicked. The server rounds it up to the minute.
+func (e *LoginError) Remaining() (time.Duration, bool) {
+	if e.Reason != enum.AccountLoginErrorReason.Kicked {
+		return 0, false
+	}
+
+	sli := strings.Split(e.Extra, "|")
+	if len(sli) != 3 {
+		return 0, false
+	}
+	var units [3]int
+	for i, s := range sli {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		units[i] = n
+	}
+	return time.Duration(units[0])*24*time.Hour + time.Duration(units[1])*time.Hour +
+		time.Duration(units[2])*time.Minute, true
+}
+
+// ServerMessageError is an AksServerMessage sent by the server before closing the
+// connection, like "016" when it has too many connections.
+type ServerMessageError struct {
+	Value string
+}
+
+func (e *ServerMessageError) Error() string {
+	return fmt.Sprintf("server message %q", e.Value)
+}
+
+// SelectServerError is an AccountSelectServerError sent by the server.
+type SelectServerError struct {
+	Reason rune
+	Extra  string
+}
+
+func (e *SelectServerError) Error() string {
+	if e.Extra == "" {
+		return fmt.Sprintf("could not select server: reason %q", e.Reason)
+	}
+	return fmt.Sprintf("could not select server: reason %q: %s", e.Reason, e.Extra)
+}
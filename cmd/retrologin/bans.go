@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kralamoure/dofus"
+
+	"github.com/kralamoure/retrologin"
+)
+
+// pgBans looks bans up in the tables of assets/postgres-bans.sql, which dofuspg
+// has none of.
+type pgBans struct {
+	pool *pgxpool.Pool
+}
+
+func (r pgBans) AccountBan(ctx context.Context, account dofus.Account, user dofus.User) (retrologin.Ban, bool, error) {
+	query := "SELECT until, reason" +
+		" FROM retrologin.account_bans" +
+		" WHERE account_id = $1;"
+
+	return scanPgBan(r.pool.QueryRow(ctx, query, account.Id))
+}
+
+func (r pgBans) AccountBansSince(ctx context.Context, since time.Time) (map[string]retrologin.Ban, error) {
+	query := "SELECT account_id, until, reason" +
+		" FROM retrologin.account_bans" +
+		" WHERE created >= $1;"
+
+	rows, err := r.pool.Query(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make(map[string]retrologin.Ban)
+	for rows.Next() {
+		var accountId string
+		var until *time.Time
+		var ban retrologin.Ban
+		err := rows.Scan(&accountId, &until, &ban.Reason)
+		if err != nil {
+			return nil, err
+		}
+		if until != nil {
+			ban.Until = *until
+		}
+		bans[accountId] = ban
+	}
+	return bans, rows.Err()
+}
+
+func (r pgBans) IPBan(ctx context.Context, ip string) (retrologin.Ban, bool, error) {
+	query := "SELECT until, reason" +
+		" FROM retrologin.ip_bans" +
+		" WHERE ip = $1;"
+
+	return scanPgBan(r.pool.QueryRow(ctx, query, ip))
+}
+
+func scanPgBan(row pgx.Row) (retrologin.Ban, bool, error) {
+	var until *time.Time
+	var ban retrologin.Ban
+	err := row.Scan(&until, &ban.Reason)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return retrologin.Ban{}, false, nil
+		}
+		return retrologin.Ban{}, false, err
+	}
+	if until != nil {
+		ban.Until = *until
+	}
+	return ban, true, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+	"gopkg.in/yaml.v3"
+)
+
+// seed is the content of a seed file of the memory or SQLite storage, in YAML or
+// JSON.
+type seed struct {
+	Users       []seedUser       `yaml:"users"`
+	GameServers []seedGameServer `yaml:"gameservers"`
+	Characters  []seedCharacter  `yaml:"characters"`
+}
+
+type seedUser struct {
+	Id string `yaml:"id"`
+	// Email is required, as emails are unique, even empty ones.
+	Email    string `yaml:"email"`
+	Nickname string `yaml:"nickname"`
+	// Password is hashed with argon2id on load. Hash is used as is, in any of the
+	// formats of the built-in hash verifiers.
+	Password       string `yaml:"password"`
+	Hash           string `yaml:"hash"`
+	SecretQuestion string `yaml:"secret_question"`
+	SecretAnswer   string `yaml:"secret_answer"`
+	// Community defaults to the international one, as in PostgreSQL.
+	Community *int          `yaml:"community"`
+	Accounts  []seedAccount `yaml:"accounts"`
+}
+
+type seedAccount struct {
+	Id           string    `yaml:"id"`
+	Name         string    `yaml:"name"`
+	Admin        bool      `yaml:"admin"`
+	Subscription time.Time `yaml:"subscription"`
+}
+
+type seedGameServer struct {
+	Id         int    `yaml:"id"`
+	Host       string `yaml:"host"`
+	Port       string `yaml:"port"`
+	State      string `yaml:"state"`
+	Completion int    `yaml:"completion"`
+}
+
+type seedCharacter struct {
+	Id         int    `yaml:"id"`
+	Name       string `yaml:"name"`
+	Account    string `yaml:"account"`
+	GameServer int    `yaml:"gameserver"`
+	Class      int    `yaml:"class"`
+	Sex        int    `yaml:"sex"`
+}
+
+// seedStorage is a storage that can be filled with a seed file.
+type seedStorage interface {
+	CreateUser(ctx context.Context, user dofus.User) (string, error)
+	CreateAccount(ctx context.Context, account dofus.Account) (string, error)
+	CreateGameServer(ctx context.Context, gameServer retro.GameServer) error
+	CreateCharacter(ctx context.Context, character retro.Character) (int, error)
+	AccountByName(ctx context.Context, name string) (dofus.Account, error)
+}
+
+// loadSeed fills the storage with the content of the seed file, if any.
+func loadSeed(ctx context.Context, storage seedStorage, name string, hashParams *argon2id.Params) error {
+	if name == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var s seed
+	err = yaml.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("could not parse seed file: %w", err)
+	}
+
+	for _, u := range s.Users {
+		if u.Email == "" {
+			return fmt.Errorf("user %q has no email", u.Nickname)
+		}
+		hash := u.Hash
+		switch {
+		case u.Password != "" && u.Hash != "":
+			return fmt.Errorf("user %q has both a password and a hash", u.Nickname)
+		case u.Password != "":
+			hash, err = argon2id.CreateHash(u.Password, hashParams)
+			if err != nil {
+				return err
+			}
+		case u.Hash == "":
+			return fmt.Errorf("user %q has no password nor hash", u.Nickname)
+		}
+		community := dofustyp.CommunityInternational
+		if u.Community != nil {
+			community = dofustyp.Community(*u.Community)
+		}
+
+		userId, err := storage.CreateUser(ctx, dofus.User{
+			Id:             u.Id,
+			Email:          dofustyp.Email(u.Email),
+			Nickname:       dofustyp.Nickname(u.Nickname),
+			Hash:           dofustyp.Hash(hash),
+			SecretQuestion: u.SecretQuestion,
+			SecretAnswer:   u.SecretAnswer,
+			Community:      community,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create user %q: %w", u.Nickname, err)
+		}
+
+		for _, a := range u.Accounts {
+			_, err := storage.CreateAccount(ctx, dofus.Account{
+				Id:           a.Id,
+				UserId:       userId,
+				Name:         dofustyp.AccountName(a.Name),
+				Subscription: a.Subscription,
+				Admin:        a.Admin,
+			})
+			if err != nil {
+				return fmt.Errorf("could not create account %q: %w", a.Name, err)
+			}
+		}
+	}
+
+	for _, g := range s.GameServers {
+		state := retrotyp.GameServerStateOnline
+		if g.State != "" {
+			state = -1
+			for k, v := range retrotyp.GameServerStates {
+				if v == g.State {
+					state = k
+				}
+			}
+			if state == -1 {
+				return fmt.Errorf("invalid state %q of game server %d", g.State, g.Id)
+			}
+		}
+
+		err := storage.CreateGameServer(ctx, retro.GameServer{
+			Id:         g.Id,
+			Host:       g.Host,
+			Port:       g.Port,
+			State:      state,
+			Completion: g.Completion,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create game server %d: %w", g.Id, err)
+		}
+	}
+
+	for _, c := range s.Characters {
+		account, err := storage.AccountByName(ctx, c.Account)
+		if err != nil {
+			if errors.Is(err, dofus.ErrNotFound) {
+				return fmt.Errorf("unknown account %q of character %q", c.Account, c.Name)
+			}
+			return err
+		}
+
+		_, err = storage.CreateCharacter(ctx, retro.Character{
+			Id:           c.Id,
+			AccountId:    account.Id,
+			GameServerId: c.GameServer,
+			Name:         retrotyp.CharacterName(c.Name),
+			ClassId:      retrotyp.ClassId(c.Class),
+			Sex:          retrotyp.Sex(c.Sex),
+		})
+		if err != nil {
+			return fmt.Errorf("could not create character %q: %w", c.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kralamoure/dofus/dofussvc"
+	"github.com/kralamoure/dofuspg"
+	"github.com/kralamoure/retro/retrosvc"
+	"github.com/kralamoure/retropg"
+
+	"github.com/kralamoure/retrologin"
+	"github.com/kralamoure/retrologin/sqlitedb"
+)
+
+// openPostgres returns the storage of the PostgreSQL database, and the pool of
+// its connections.
+func openPostgres(ctx context.Context, connString string) (retrologin.Storage, *pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dofusDb, err := dofuspg.NewDb(pool)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+
+	retroDb, err := retropg.NewDb(pool)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+
+	dofusSvc, err := dofussvc.NewService(dofusDb)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+
+	retroSvc, err := retrosvc.NewService(retrosvc.Config{
+		Storer: retroDb,
+	})
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+
+	return retrologin.NewServiceStorage(dofusSvc, retroSvc), pool, nil
+}
+
+// openSQLite opens the SQLite database file, creating and seeding it if it is
+// missing. A seed file is refused for an existing database, which is not seeded.
+func openSQLite(ctx context.Context, name, seedFile string, hashParams *argon2id.Params) (*sqlitedb.Db, error) {
+	_, err := os.Stat(name)
+	created := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !created {
+		return nil, err
+	}
+	if !created && seedFile != "" {
+		return nil, fmt.Errorf("the SQLite database %s already exists, so it can't be seeded", name)
+	}
+
+	db, err := sqlitedb.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return db, nil
+	}
+
+	err = loadSeed(ctx, db, seedFile, hashParams)
+	if err != nil {
+		db.Close()
+		os.Remove(name)
+		return nil, err
+	}
+	return db, nil
+}
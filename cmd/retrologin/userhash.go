@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+)
+
+// pgUserHashes updates the password hashes in the users table of dofuspg, which
+// the dofus service has no method for.
+type pgUserHashes struct {
+	pool *pgxpool.Pool
+}
+
+func (r pgUserHashes) SetUserHash(ctx context.Context, id string, hash dofustyp.Hash) error {
+	query := "UPDATE dofus.users" +
+		" SET hash = $2" +
+		" WHERE id = $1;"
+
+	tag, err := r.pool.Exec(ctx, query, id, hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return dofus.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package retrologin
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// connLimiter caps the number of connections, both in total and per IP address.
+// Addresses in the exempt networks are only subject to the total cap.
+type connLimiter struct {
+	max      int
+	maxPerIP int
+	exempt   []*net.IPNet
+
+	mu    sync.Mutex
+	total int
+	byIP  map[string]int
+}
+
+func newConnLimiter(max, maxPerIP int, exempt []string) (*connLimiter, error) {
+	l := &connLimiter{
+		max:      max,
+		maxPerIP: maxPerIP,
+		byIP:     make(map[string]int),
+	}
+
+	nets, err := parseCIDRs(exempt)
+	if err != nil {
+		return nil, err
+	}
+	l.exempt = nets
+
+	return l, nil
+}
+
+// admit reserves a connection for ip, unless a cap is reached, in which case it
+// returns the server message explaining why. Connections without an IP address,
+// like those of a net.Pipe, are only subject to the total cap.
+func (l *connLimiter) admit(ip net.IP) (msg string, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return serverMessageServerFull, false
+	}
+	if ip == nil {
+		l.total++
+		return "", true
+	}
+
+	key := ipKey(ip)
+	if l.maxPerIP > 0 && l.byIP[key] >= l.maxPerIP && !containsIP(l.exempt, ip) {
+		return serverMessageTooManyConnections, false
+	}
+
+	l.total++
+	l.byIP[key]++
+	return "", true
+}
+
+func (l *connLimiter) release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if ip == nil {
+		return
+	}
+	key := ipKey(ip)
+	l.byIP[key]--
+	if l.byIP[key] <= 0 {
+		delete(l.byIP, key)
+	}
+}
+
+// ipKey identifies the client at ip for the per-IP limits. IPv6 clients are
+// identified by their /64 prefix, which is what a single subscriber is usually
+// given.
+func ipKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package retrologin
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l, err := newConnLimiter(4, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip  net.IP
+		msg string
+		ok  bool
+	}{
+		{net.ParseIP("2001:db8::1"), "", true},
+		{net.ParseIP("2001:db8::2"), serverMessageTooManyConnections, false},
+		{net.ParseIP("192.0.2.1"), "", true},
+		// Without an IP address, as with a net.Pipe, only the total cap applies.
+		{nil, "", true},
+		{nil, "", true},
+		{net.ParseIP("192.0.2.2"), serverMessageServerFull, false},
+	}
+
+	for _, test := range tests {
+		msg, ok := l.admit(test.ip)
+		if msg != test.msg || ok != test.ok {
+			t.Errorf("admit(%v) = %q, %t, want %q, %t", test.ip, msg, ok, test.msg, test.ok)
+		}
+	}
+
+	l.release(nil)
+	msg, ok := l.admit(net.ParseIP("192.0.2.2"))
+	if !ok {
+		t.Errorf("admit after a release = %q, %t, want true", msg, ok)
+	}
+}
@@ -0,0 +1,94 @@
+// Package credential encrypts and decrypts the passwords of account credentials
+// the same way the official client does, for both sides of the login protocol.
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CryptoMethodSalt is the crypto method of the official client, which encrypts
+// the password with the salt sent in AksHelloConnect.
+const CryptoMethodSalt = 1
+
+const passwordHash = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+
+// EncryptedPassword encrypts password with key the same way the official client
+// does for CryptoMethodSalt, key being the salt sent in AksHelloConnect.
+func EncryptedPassword(password, key string) (string, error) {
+	if key == "" {
+		return "", errors.New("key is empty")
+	}
+	runes := []rune(password)
+	if len(runes) == 0 || len(runes) > 32 {
+		return "", errors.New("the password must have between 1 and 32 characters")
+	}
+	if len(runes) > len(key) {
+		return "", errors.New("the password is longer than the key")
+	}
+
+	sb := &strings.Builder{}
+
+	for i, r := range runes {
+		if int(r) >= len(passwordHash)*16 {
+			return "", fmt.Errorf("the password contains an unsupported character: %q", r)
+		}
+		pKey := int(key[i])
+		sb.WriteByte(passwordHash[(int(r)/16+pKey)%len(passwordHash)])
+		sb.WriteByte(passwordHash[(int(r)%16+pKey)%len(passwordHash)])
+	}
+
+	return sb.String(), nil
+}
+
+// DecryptedPassword recovers the password that EncryptedPassword encrypted with
+// key.
+func DecryptedPassword(encryptedPassword, key string) (string, error) {
+	if key == "" {
+		return "", errors.New("key is empty")
+	}
+	if len(encryptedPassword)%2 != 0 ||
+		!regexp.MustCompile(`^[a-zA-Z\d\-_]{2,64}$`).MatchString(encryptedPassword) {
+		return "", errors.New("the encrypted password is malformed")
+	}
+	if len(encryptedPassword)/2 > len(key) {
+		return "", errors.New("the encrypted password is longer than the key")
+	}
+
+	hashMap := make(map[rune]rune)
+	for i, v := range passwordHash {
+		hashMap[v] = rune(i)
+	}
+
+	var pPass, pKey rune
+	var aPass, aKey, anb, anb2, sum1, sum2 int
+
+	sb := &strings.Builder{}
+
+	for i := 0; i < len(encryptedPassword); i += 2 {
+		pKey = rune(key[i/2])
+		anb = int(hashMap[rune(encryptedPassword[i])])
+		anb2 = int(hashMap[rune(encryptedPassword[i+1])])
+		sum1 = anb + len(passwordHash)
+		sum2 = anb2 + len(passwordHash)
+
+		aPass = sum1 - int(pKey)
+		if aPass < 0 {
+			aPass += len(passwordHash)
+		}
+		aPass *= 16
+
+		aKey = sum2 - int(pKey)
+		if aKey < 0 {
+			aKey += len(passwordHash)
+		}
+
+		pPass = rune(aPass + aKey)
+
+		sb.WriteRune(pPass)
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,122 @@
+package credential
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+type validPassword string
+
+func (validPassword) Generate(r *rand.Rand, _ int) reflect.Value {
+	runes := make([]rune, 1+r.Intn(32))
+	for i := range runes {
+		runes[i] = rune(r.Intn(len(passwordHash) * 16))
+	}
+	return reflect.ValueOf(validPassword(runes))
+}
+
+type validSalt string
+
+func (validSalt) Generate(r *rand.Rand, _ int) reflect.Value {
+	const charset = "abcdefghijklmnopqrstuvwxyz"
+
+	b := make([]byte, 32)
+	for i := range b {
+		b[i] = charset[r.Intn(len(charset))]
+	}
+	return reflect.ValueOf(validSalt(b))
+}
+
+func TestEncryptedPasswordRoundTrip(t *testing.T) {
+	f := func(password validPassword, salt validSalt) bool {
+		encrypted, err := EncryptedPassword(string(password), string(salt))
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		decrypted, err := DecryptedPassword(encrypted, string(salt))
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		return decrypted == string(password)
+	}
+
+	err := quick.Check(f, &quick.Config{MaxCount: 10000})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncryptedPasswordInvalid(t *testing.T) {
+	salt := strings.Repeat("a", 32)
+
+	tests := []struct {
+		name     string
+		password string
+		key      string
+	}{
+		{name: "empty key", password: "password", key: ""},
+		{name: "empty password", password: "", key: salt},
+		{name: "too long", password: strings.Repeat("a", 33), key: strings.Repeat("a", 33)},
+		{name: "longer than key", password: "password", key: "abc"},
+		{name: "unsupported character", password: "passЀword", key: salt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EncryptedPassword(tt.password, tt.key)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestDecryptedPasswordLongerThanKey(t *testing.T) {
+	_, err := DecryptedPassword(strings.Repeat("ab", 32), "abc")
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func FuzzDecryptedPassword(f *testing.F) {
+	f.Add("QaQa", "ab")
+	f.Add(strings.Repeat("ab", 32), "abc")
+	f.Add("-_-_", strings.Repeat("z", 32))
+	f.Add("aa", "\xff")
+
+	f.Fuzz(func(t *testing.T, encrypted, key string) {
+		_, err := DecryptedPassword(encrypted, key)
+		if err == nil && len(encrypted)/2 > len(key) {
+			t.Errorf("decrypted a password of %d characters with a key of %d bytes", len(encrypted)/2, len(key))
+		}
+	})
+}
+
+func FuzzEncryptedPassword(f *testing.F) {
+	f.Add("password123", strings.Repeat("abcdefgh", 4))
+	f.Add("\x00Ͽ", "zz")
+
+	f.Fuzz(func(t *testing.T, password, key string) {
+		encrypted, err := EncryptedPassword(password, key)
+		if err != nil {
+			return
+		}
+		for i := 0; i < len([]rune(password)); i++ {
+			if key[i] < 'a' || key[i] > 'z' {
+				return
+			}
+		}
+
+		decrypted, err := DecryptedPassword(encrypted, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != password {
+			t.Errorf("got %q, want %q", decrypted, password)
+		}
+	})
+}
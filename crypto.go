@@ -2,56 +2,32 @@ package retrologin
 
 import (
 	crand "crypto/rand"
-	"errors"
-	"regexp"
-	"strings"
-)
-
-func decryptedPassword(encryptedPassword, key string) (string, error) {
-	if key == "" {
-		return "", errors.New("key is empty")
-	}
-	if len(encryptedPassword)%2 != 0 ||
-		!regexp.MustCompile(`^[a-zA-Z\d\-_]{2,64}$`).MatchString(encryptedPassword) {
-		return "", errors.New("the encrypted password is malformed")
-	}
-
-	hash := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
-
-	hashMap := make(map[rune]rune)
-	for i, v := range hash {
-		hashMap[v] = rune(i)
-	}
-
-	var pPass, pKey rune
-	var aPass, aKey, anb, anb2, sum1, sum2 int
 
-	sb := &strings.Builder{}
+	"github.com/kralamoure/retrologin/credential"
+)
 
-	for i := 0; i < len(encryptedPassword); i += 2 {
-		pKey = rune(key[i/2])
-		anb = int(hashMap[rune(encryptedPassword[i])])
-		anb2 = int(hashMap[rune(encryptedPassword[i+1])])
-		sum1 = anb + len(hash)
-		sum2 = anb2 + len(hash)
+// CryptoMethodSalt is the crypto method of the official client, which encrypts
+// the password with the salt sent in AksHelloConnect.
+const CryptoMethodSalt = credential.CryptoMethodSalt
 
-		aPass = sum1 - int(pKey)
-		if aPass < 0 {
-			aPass += len(hash)
-		}
-		aPass *= 16
+// CredentialDecoder recovers the password from the hash of an account credential
+// encoded with a given crypto method.
+type CredentialDecoder interface {
+	DecodePassword(hash, salt string) (password string, err error)
+}
 
-		aKey = sum2 - int(pKey)
-		if aKey < 0 {
-			aKey += len(hash)
-		}
+// CredentialDecoderFunc is an adapter to allow the use of ordinary functions as
+// credential decoders.
+type CredentialDecoderFunc func(hash, salt string) (password string, err error)
 
-		pPass = rune(aPass + aKey)
+func (f CredentialDecoderFunc) DecodePassword(hash, salt string) (password string, err error) {
+	return f(hash, salt)
+}
 
-		sb.WriteRune(pPass)
+func defaultCredentialDecoders() map[int]CredentialDecoder {
+	return map[int]CredentialDecoder{
+		CryptoMethodSalt: CredentialDecoderFunc(credential.DecryptedPassword),
 	}
-
-	return sb.String(), nil
 }
 
 func randomSalt(n int) (string, error) {
@@ -0,0 +1,28 @@
+package retrologin
+
+import (
+	"testing"
+
+	"github.com/kralamoure/retrologin/credential"
+)
+
+func TestEncryptedPasswordRoundTripRandomSalt(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		salt, err := randomSalt(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encrypted, err := credential.EncryptedPassword("password123", salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := credential.DecryptedPassword(encrypted, salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted != "password123" {
+			t.Fatalf("decrypted password with salt %q: got %q", salt, decrypted)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package retrologin_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroproto"
+	"github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgcli"
+	"github.com/kralamoure/retroproto/msgsvr"
+	prototyp "github.com/kralamoure/retroproto/typ"
+
+	"github.com/kralamoure/retrologin"
+	"github.com/kralamoure/retrologin/credential"
+	"github.com/kralamoure/retrologin/retrologintest"
+)
+
+var e2eVersion = msgcli.AccountVersion{Major: 1, Minor: 29, Patch: 1}
+
+// scriptedClient plays the client side of the protocol, failing the test on any
+// unexpected packet.
+type scriptedClient struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+	salt string
+}
+
+type msgCli interface {
+	ProtocolId() retroproto.MsgCliId
+	Serialized() (string, error)
+}
+
+// dial connects to addr and reads the salt of AksHelloConnect.
+func dial(t *testing.T, addr string) *scriptedClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &scriptedClient{t: t, conn: conn, rd: bufio.NewReader(conn)}
+
+	var hello msgsvr.AksHelloConnect
+	c.expect(retroproto.AksHelloConnect, &hello)
+	c.salt = hello.Salt
+
+	return c
+}
+
+func (c *scriptedClient) send(msg msgCli) {
+	c.t.Helper()
+
+	c.sendPacket(packet(c.t, msg))
+}
+
+func (c *scriptedClient) sendPacket(pkt string) {
+	c.t.Helper()
+
+	_, err := io.WriteString(c.conn, pkt+"\n\x00")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func packet(t *testing.T, msg msgCli) string {
+	t.Helper()
+
+	extra, err := msg.Serialized()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The version and the credential are sent without any prefix.
+	switch id := msg.ProtocolId(); id {
+	case retroproto.AccountVersion, retroproto.AccountCredential:
+		return extra
+	default:
+		return string(id) + extra
+	}
+}
+
+func (c *scriptedClient) credential(username, password string) msgcli.AccountCredential {
+	c.t.Helper()
+
+	hash, err := credential.EncryptedPassword(password, c.salt)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msgcli.AccountCredential{
+		Username:     username,
+		Hash:         hash,
+		CryptoMethod: credential.CryptoMethodSalt,
+	}
+}
+
+// receive returns the next packet, or io.EOF once the server closed the
+// connection.
+func (c *scriptedClient) receive() (string, error) {
+	c.t.Helper()
+
+	err := c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		pkt, err := c.rd.ReadString('\x00')
+		if err != nil {
+			if errors.Is(err, io.EOF) && pkt == "" {
+				return "", io.EOF
+			}
+			return "", err
+		}
+		return strings.TrimSuffix(pkt, "\x00"), nil
+	}
+}
+
+// expect receives a packet of the message id, and deserializes it into msg if
+// it is not nil. Queue positions, which are sent at any time while waiting in
+// the queue, are skipped unless they are expected.
+func (c *scriptedClient) expect(id retroproto.MsgSvrId, msg interface{ Deserialize(string) error }) {
+	c.t.Helper()
+
+	var pkt string
+	var got retroproto.MsgSvrId
+	var err error
+	for {
+		pkt, err = c.receive()
+		if err != nil {
+			c.t.Fatalf("waiting for %s: %v", id, err)
+		}
+		got, _ = retroproto.MsgSvrIdByPkt(pkt)
+		if got != retroproto.AccountNewQueue || id == retroproto.AccountNewQueue {
+			break
+		}
+	}
+	if got != id {
+		c.t.Fatalf("received %q, want a %s packet", pkt, id)
+	}
+	if msg == nil {
+		return
+	}
+	err = msg.Deserialize(strings.TrimPrefix(pkt, string(id)))
+	if err != nil {
+		c.t.Fatalf("could not deserialize %q: %v", pkt, err)
+	}
+}
+
+// expectLoginError receives AccountLoginError with the reason, then the end of
+// the connection.
+func (c *scriptedClient) expectLoginError(reason rune) msgsvr.AccountLoginError {
+	c.t.Helper()
+
+	var msg msgsvr.AccountLoginError
+	c.expect(retroproto.AccountLoginError, &msg)
+	if msg.Reason != reason {
+		c.t.Fatalf("login error reason = %q, want %q", msg.Reason, reason)
+	}
+	c.expectClosed()
+	return msg
+}
+
+// expectRejected expects the connection to be closed before any packet of a
+// login, let alone its success, was received.
+func (c *scriptedClient) expectRejected() {
+	c.t.Helper()
+
+	pkt, err := c.receive()
+	if err == nil {
+		switch id, _ := retroproto.MsgSvrIdByPkt(pkt); id {
+		case retroproto.AccountPseudo, retroproto.AccountCommunity, retroproto.AccountSecretQuestion,
+			retroproto.AccountHosts, retroproto.AccountLoginSuccess:
+			c.t.Fatalf("received the login packet %q, want the connection to be closed", pkt)
+		}
+		c.t.Fatalf("received %q, want the connection to be closed", pkt)
+	}
+	if !errors.Is(err, io.EOF) {
+		c.t.Fatalf("waiting for the connection to be closed: %v", err)
+	}
+}
+
+func (c *scriptedClient) expectClosed() {
+	c.t.Helper()
+
+	pkt, err := c.receive()
+	if err == nil {
+		c.t.Fatalf("received %q, want the connection to be closed", pkt)
+	}
+	if !errors.Is(err, io.EOF) {
+		c.t.Fatalf("waiting for the connection to be closed: %v", err)
+	}
+}
+
+// login sends the version and the credential, joins the queue, and receives the
+// messages up to AccountLoginSuccess, starting with the first queue position.
+func (c *scriptedClient) login(username, password string) (msgsvr.AccountNewQueue, msgsvr.AccountPseudo,
+	msgsvr.AccountHosts) {
+	c.t.Helper()
+
+	c.send(e2eVersion)
+	c.send(c.credential(username, password))
+	c.send(msgcli.AccountQueuePosition{})
+
+	var queue msgsvr.AccountNewQueue
+	c.expect(retroproto.AccountNewQueue, &queue)
+	var pseudo msgsvr.AccountPseudo
+	c.expect(retroproto.AccountPseudo, &pseudo)
+	c.expect(retroproto.AccountCommunity, nil)
+	c.expect(retroproto.AccountSecretQuestion, nil)
+	var hosts msgsvr.AccountHosts
+	c.expect(retroproto.AccountHosts, &hosts)
+	c.expect(retroproto.AccountLoginSuccess, nil)
+
+	return queue, pseudo, hosts
+}
+
+func TestE2ELogin(t *testing.T) {
+	svr := retrologintest.Start(t, retrologin.Config{QueueId: 42, TicketDur: time.Minute})
+	c := dial(t, svr.Addr)
+
+	queue, pseudo, hosts := c.login("PLAYER", retrologintest.Password)
+	// The account is a subscriber, alone in the queue.
+	wantQueue := msgsvr.AccountNewQueue{Position: 1, TotalAbo: 1, Subscriber: true, QueueId: 42}
+	if queue != wantQueue {
+		t.Errorf("queue position = %+v, want %+v", queue, wantQueue)
+	}
+	if pseudo.Value != "Player" {
+		t.Errorf("nickname = %q, want %q", pseudo.Value, "Player")
+	}
+	want := []prototyp.AccountHostsHost{{
+		Id:     retrologintest.GameServerId,
+		State:  int(retrotyp.GameServerStateOnline),
+		CanLog: true,
+	}}
+	if len(hosts.Value) != 1 || hosts.Value[0] != want[0] {
+		t.Errorf("hosts = %+v, want %+v", hosts.Value, want)
+	}
+
+	// A queue position asked for before the login succeeded may arrive after it,
+	// and is ignored.
+	c.send(msgcli.AccountQueuePosition{})
+
+	c.send(msgcli.AccountGetServersList{})
+	var list msgsvr.AccountServersListSuccess
+	c.expect(retroproto.AccountServersListSuccess, &list)
+	if len(list.ServersCharacters) != 1 || list.ServersCharacters[0].Id != retrologintest.GameServerId ||
+		list.ServersCharacters[0].Qty != 1 {
+		t.Errorf("characters by server = %+v, want 1 on server %d", list.ServersCharacters, retrologintest.GameServerId)
+	}
+
+	c.send(msgcli.AccountSetServer{Id: retrologintest.GameServerId})
+	var selected msgsvr.AccountSelectServerPlainSuccess
+	c.expect(retroproto.AccountSelectServerPlainSuccess, &selected)
+	if selected.Host != "127.0.0.1" || selected.Port != "5556" {
+		t.Errorf("game server address = %s:%s, want 127.0.0.1:5556", selected.Host, selected.Port)
+	}
+	c.expectClosed()
+
+	ticket, err := svr.Storage.UseTicket(context.Background(), selected.Ticket)
+	if err != nil {
+		t.Fatalf("ticket %q: %v", selected.Ticket, err)
+	}
+	if ticket.AccountId != svr.AccountId || ticket.GameServerId != retrologintest.GameServerId {
+		t.Errorf("ticket = %+v, want one of account %q to game server %d", ticket, svr.AccountId, retrologintest.GameServerId)
+	}
+}
+
+func TestE2EBadVersion(t *testing.T) {
+	addr := retrologintest.Start(t, retrologin.Config{}).Addr
+	c := dial(t, addr)
+
+	c.send(msgcli.AccountVersion{Major: 1, Minor: 28, Patch: 0})
+	c.send(c.credential("player", retrologintest.Password))
+	c.send(msgcli.AccountQueuePosition{})
+
+	msg := c.expectLoginError(enum.AccountLoginErrorReason.BadVersion)
+	if msg.Extra != retrologin.DefaultClientVersions {
+		t.Errorf("required version = %q, want %q", msg.Extra, retrologin.DefaultClientVersions)
+	}
+}
+
+func TestE2EBadCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"bad password", "player", "password456"},
+		{"unknown account", "unknown", retrologintest.Password},
+	}
+
+	addr := retrologintest.Start(t, retrologin.Config{}).Addr
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			c := dial(t, addr)
+
+			c.send(e2eVersion)
+			c.send(c.credential(test.username, test.password))
+			c.send(msgcli.AccountQueuePosition{})
+
+			c.expectLoginError(enum.AccountLoginErrorReason.AccessDenied)
+		})
+	}
+}
+
+func TestE2EAlreadyLogged(t *testing.T) {
+	t.Run("reject", func(t *testing.T) {
+		addr := retrologintest.Start(t, retrologin.Config{Takeover: retrologin.TakeoverReject}).Addr
+		first := dial(t, addr)
+		first.login("player", retrologintest.Password)
+
+		second := dial(t, addr)
+		second.send(e2eVersion)
+		second.send(second.credential("player", retrologintest.Password))
+		second.send(msgcli.AccountQueuePosition{})
+		second.expectLoginError(enum.AccountLoginErrorReason.AlreadyLogged)
+
+		// The first session is left alone.
+		first.send(msgcli.AccountGetServersList{})
+		first.expect(retroproto.AccountServersListSuccess, nil)
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		addr := retrologintest.Start(t, retrologin.Config{Takeover: retrologin.TakeoverKick}).Addr
+		first := dial(t, addr)
+		first.login("player", retrologintest.Password)
+
+		second := dial(t, addr)
+		second.login("player", retrologintest.Password)
+		first.expectLoginError(enum.AccountLoginErrorReason.AlreadyLogged)
+
+		second.send(msgcli.AccountGetServersList{})
+		second.expect(retroproto.AccountServersListSuccess, nil)
+	})
+}
+
+func TestE2ESearchForFriend(t *testing.T) {
+	addr := retrologintest.Start(t, retrologin.Config{}).Addr
+	c := dial(t, addr)
+	c.login("player", retrologintest.Password)
+
+	c.send(msgcli.AccountSearchForFriend{Pseudo: "player"})
+	var found msgsvr.AccountFriendServerList
+	c.expect(retroproto.AccountFriendServerList, &found)
+	if len(found.ServersCharacters) != 1 || found.ServersCharacters[0].Id != retrologintest.GameServerId ||
+		found.ServersCharacters[0].Qty != 1 {
+		t.Errorf("characters of the friend by server = %+v, want 1 on server %d", found.ServersCharacters,
+			retrologintest.GameServerId)
+	}
+
+	c.send(msgcli.AccountSearchForFriend{Pseudo: "Nobody"})
+	var missing msgsvr.AccountFriendServerList
+	c.expect(retroproto.AccountFriendServerList, &missing)
+	if len(missing.ServersCharacters) != 0 {
+		t.Errorf("characters of a missing friend = %+v, want none", missing.ServersCharacters)
+	}
+}
+
+func TestE2EOutOfOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		script func(c *scriptedClient)
+	}{
+		{"credential before version", func(c *scriptedClient) {
+			// Framed as a credential, and not as a version that could not be parsed.
+			pkt := packet(c.t, c.credential("player", retrologintest.Password))
+			if id, _ := retroproto.MsgCliIdByPkt(pkt); id != retroproto.AccountCredential {
+				c.t.Fatalf("credential %q is framed as %q", pkt, id)
+			}
+			c.sendPacket(pkt)
+		}},
+		{"unparsable version", func(c *scriptedClient) {
+			c.sendPacket("1.29")
+		}},
+		{"queue before credential", func(c *scriptedClient) {
+			c.send(e2eVersion)
+			c.send(msgcli.AccountQueuePosition{})
+		}},
+		{"version twice", func(c *scriptedClient) {
+			c.send(e2eVersion)
+			c.send(e2eVersion)
+		}},
+		{"servers list before login", func(c *scriptedClient) {
+			c.send(e2eVersion)
+			c.send(c.credential("player", retrologintest.Password))
+			c.send(msgcli.AccountGetServersList{})
+		}},
+		{"credential after login", func(c *scriptedClient) {
+			c.login("player", retrologintest.Password)
+			c.send(c.credential("player", retrologintest.Password))
+		}},
+		{"version after login", func(c *scriptedClient) {
+			c.login("player", retrologintest.Password)
+			c.send(e2eVersion)
+		}},
+	}
+
+	addr := retrologintest.Start(t, retrologin.Config{}).Addr
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			c := dial(t, addr)
+			test.script(c)
+			c.expectRejected()
+		})
+	}
+}
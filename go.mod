@@ -1,8 +1,9 @@
 module github.com/kralamoure/retrologin
 
-go 1.17
+go 1.18
 
 require (
+	github.com/Masterminds/semver/v3 v3.2.1
 	github.com/alexedwards/argon2id v0.0.0-20211130144151-3585854a6387
 	github.com/happybydefault/logging v0.0.0-20210507180050-0f3842239c0e
 	github.com/jackc/pgx/v4 v4.16.0
@@ -11,10 +12,13 @@ require (
 	github.com/kralamoure/retro v0.0.0-20210524205513-a4b1f4842c56
 	github.com/kralamoure/retropg v0.0.0-20210524204800-ec8e5261c360
 	github.com/kralamoure/retroproto v0.0.0-20220319231200-2ad29e6220eb
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/spf13/pflag v1.0.5
 	go.uber.org/atomic v1.9.0
 	go.uber.org/zap v1.21.0
+	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f
 	golang.org/x/time v0.0.0-20220411224347-583f2d630306
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 require (
@@ -31,7 +35,6 @@ require (
 	github.com/kralamoure/retroutil v0.0.0-20210518132922-a957c67f4004 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go.uber.org/multierr v1.8.0 // indirect
-	golang.org/x/crypto v0.0.0-20220427172511-eb4f295cb31f // indirect
 	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
 	golang.org/x/text v0.3.8 // indirect
 )
@@ -0,0 +1,165 @@
+package retrologin
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/kralamoure/dofus/dofustyp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errUnknownHashFormat = errors.New("unknown password hash format")
+
+// HashVerifier verifies passwords against the stored hashes of a given format.
+type HashVerifier interface {
+	// Detects reports whether hash is in the format of the verifier.
+	Detects(hash string) bool
+	Verify(password, hash string) (match bool, err error)
+}
+
+// UserHashUpdater stores the hash of a user's password, which is rehashed with
+// argon2id after a successful login with a legacy hash format.
+type UserHashUpdater interface {
+	SetUserHash(ctx context.Context, id string, hash dofustyp.Hash) error
+}
+
+// Argon2idHashes verifies argon2id hashes in the PHC string format.
+var Argon2idHashes HashVerifier = argon2idHashes{}
+
+// BcryptHashes verifies bcrypt hashes in the modular crypt format ($2a$, $2b$,
+// $2y$).
+var BcryptHashes HashVerifier = bcryptHashes{}
+
+// SHA1Hashes verifies unsalted SHA-1 hashes encoded in hexadecimal.
+var SHA1Hashes HashVerifier = sha1Hashes{}
+
+// SaltedMD5Hashes verifies MD5 hashes of the salt followed by the password, in
+// the format "$md5$<salt>$<hexadecimal digest>".
+var SaltedMD5Hashes HashVerifier = saltedMD5Hashes{}
+
+func defaultHashVerifiers() []HashVerifier {
+	return []HashVerifier{Argon2idHashes, BcryptHashes, SHA1Hashes, SaltedMD5Hashes}
+}
+
+type argon2idHashes struct{}
+
+func (argon2idHashes) Detects(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (argon2idHashes) Verify(password, hash string) (bool, error) {
+	return argon2id.ComparePasswordAndHash(password, hash)
+}
+
+type bcryptHashes struct{}
+
+var bcryptHashRegexp = regexp.MustCompile(`^\$2[aby]\$\d{2}\$`)
+
+func (bcryptHashes) Detects(hash string) bool {
+	return bcryptHashRegexp.MatchString(hash)
+}
+
+func (bcryptHashes) Verify(password, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type sha1Hashes struct{}
+
+var sha1HashRegexp = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+func (sha1Hashes) Detects(hash string) bool {
+	return sha1HashRegexp.MatchString(hash)
+}
+
+func (sha1Hashes) Verify(password, hash string) (bool, error) {
+	want, err := hex.DecodeString(hash)
+	if err != nil {
+		return false, err
+	}
+	got := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(got[:], want) == 1, nil
+}
+
+type saltedMD5Hashes struct{}
+
+var saltedMD5HashRegexp = regexp.MustCompile(`^\$md5\$[^$]*\$[0-9a-fA-F]{32}$`)
+
+func (saltedMD5Hashes) Detects(hash string) bool {
+	return saltedMD5HashRegexp.MatchString(hash)
+}
+
+func (saltedMD5Hashes) Verify(password, hash string) (bool, error) {
+	sli := strings.Split(hash, "$")
+	want, err := hex.DecodeString(sli[3])
+	if err != nil {
+		return false, err
+	}
+	got := md5.Sum([]byte(sli[2] + password))
+	return subtle.ConstantTimeCompare(got[:], want) == 1, nil
+}
+
+// verifyPassword verifies password against hash with the first verifier that
+// detects its format. It also reports whether the format is a legacy one, which
+// is any other than argon2id. Legacy hashes are cheap to verify, so password is
+// also compared against the dummy argon2id hash to take as long as a login of an
+// unknown account.
+func (s *Server) verifyPassword(ctx context.Context, password, hash string) (match, legacy bool, err error) {
+	for _, verifier := range s.hashVerifiers {
+		if !verifier.Detects(hash) {
+			continue
+		}
+		legacy = !Argon2idHashes.Detects(hash)
+
+		var verifyErr error
+		err = s.passwords.do(ctx, func() {
+			match, verifyErr = verifier.Verify(password, hash)
+			if legacy {
+				argon2id.ComparePasswordAndHash(password, s.dummyHash)
+			}
+		})
+		if err != nil {
+			return false, false, err
+		}
+		return match, legacy, verifyErr
+	}
+
+	// A hash of an unknown format is answered like a wrong password, after as long.
+	err = s.passwords.do(ctx, func() {
+		argon2id.ComparePasswordAndHash(password, s.dummyHash)
+	})
+	if err != nil {
+		return false, false, err
+	}
+	return false, false, errUnknownHashFormat
+}
+
+// rehashPassword replaces the legacy hash of a user with an argon2id one.
+func (s *Server) rehashPassword(ctx context.Context, userId, password string) error {
+	var hash string
+	var hashErr error
+	err := s.passwords.do(ctx, func() {
+		hash, hashErr = argon2id.CreateHash(password, s.hashParams)
+	})
+	if err != nil {
+		return err
+	}
+	if hashErr != nil {
+		return hashErr
+	}
+
+	return s.userHashes.SetUserHash(ctx, userId, dofustyp.Hash(hash))
+}
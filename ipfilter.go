@@ -0,0 +1,226 @@
+package retrologin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ruleNotAllowed is the rule reported for addresses missing from the allow list.
+const ruleNotAllowed = "not allowed"
+
+// ipFilter accepts client addresses that are in none of the denied networks and,
+// if there is an allow list, in one of the allowed networks. Both lists are
+// loaded from files of one CIDR or IP address per line, where blank lines and
+// those starting with "#" are ignored.
+type ipFilter struct {
+	allowFile string
+	denyFile  string
+
+	mu       sync.Mutex
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	modTimes map[string]time.Time
+	rejected map[string]uint64
+}
+
+func newIPFilter(allowFile, denyFile string) (*ipFilter, error) {
+	f := &ipFilter{
+		allowFile: allowFile,
+		denyFile:  denyFile,
+		modTimes:  make(map[string]time.Time),
+		rejected:  make(map[string]uint64),
+	}
+
+	_, err := f.changed()
+	if err != nil {
+		return nil, err
+	}
+	err = f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// check reports whether ip is accepted, or else the rule that rejects it.
+func (f *ipFilter) check(ip net.IP) (rule string, ok bool) {
+	if f == nil {
+		return "", true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, ipNet := range f.deny {
+		if ipNet.Contains(ip) {
+			rule = ipNet.String()
+			f.rejected[rule]++
+			return rule, false
+		}
+	}
+
+	if f.allowFile != "" && !containsIP(f.allow, ip) {
+		f.rejected[ruleNotAllowed]++
+		return ruleNotAllowed, false
+	}
+
+	return "", true
+}
+
+// load reads both lists again. On error, the current lists are kept.
+func (f *ipFilter) load() error {
+	allow, err := readCIDRFile(f.allowFile)
+	if err != nil {
+		return err
+	}
+	deny, err := readCIDRFile(f.denyFile)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.allow = allow
+	f.deny = deny
+
+	return nil
+}
+
+// changed reports whether a list file was modified since the last call.
+func (f *ipFilter) changed() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changed := false
+	for _, name := range []string{f.allowFile, f.denyFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(f.modTimes[name]) {
+			f.modTimes[name] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func (f *ipFilter) rejections() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rejected := make(map[string]uint64, len(f.rejected))
+	for rule, count := range f.rejected {
+		rejected[rule] = count
+	}
+	return rejected
+}
+
+func readCIDRFile(name string) ([]*net.IPNet, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cidrs []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if !strings.Contains(text, "/") {
+			ip := net.ParseIP(text)
+			if ip == nil {
+				return nil, fmt.Errorf("%s:%d: invalid IP address %q", name, line, text)
+			}
+			if ip.To4() != nil {
+				text += "/32"
+			} else {
+				text += "/128"
+			}
+		}
+		cidrs = append(cidrs, text)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return nets, nil
+}
+
+// ReloadIPFilter reads the allow and deny lists again. On error, the current
+// lists are kept.
+func (s *Server) ReloadIPFilter() error {
+	if s.ipFilter == nil {
+		return nil
+	}
+
+	err := s.ipFilter.load()
+	if err != nil {
+		return err
+	}
+	s.logger.Infow("reloaded IP filter")
+	return nil
+}
+
+// watchIPFilter reloads the allow and deny lists when their files change, and
+// logs the rejections by rule every minute.
+func (s *Server) watchIPFilter(ctx context.Context, d time.Duration) error {
+	if s.ipFilter == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	statsTicker := time.NewTicker(1 * time.Minute)
+	defer statsTicker.Stop()
+
+	last := make(map[string]uint64)
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := s.ipFilter.changed()
+			if err == nil && changed {
+				err = s.ReloadIPFilter()
+			}
+			if err != nil {
+				s.logger.Errorw(fmt.Errorf("could not reload IP filter: %w", err).Error())
+			}
+		case <-statsTicker.C:
+			rejected := s.ipFilter.rejections()
+			for rule, count := range rejected {
+				if count > last[rule] {
+					s.logger.Infow("rejected client connections",
+						"rule", rule,
+						"count", count-last[rule],
+					)
+				}
+			}
+			last = rejected
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
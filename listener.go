@@ -0,0 +1,103 @@
+package retrologin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// ListenerConfig is an address the server listens on, with the settings of the
+// clients connecting through it.
+type ListenerConfig struct {
+	Addr string
+	// Network is the network of Addr, as in Config.
+	Network string
+	// LoginTimeout, QueueTimeout and IdleTimeout override those of Config for the
+	// clients of the listener. Zero means the ones of Config.
+	LoginTimeout time.Duration
+	QueueTimeout time.Duration
+	IdleTimeout  time.Duration
+	// AllowedNetworks, in CIDR notation, restrict the clients of the listener, on
+	// top of the allow and deny lists of Config. Empty means any network.
+	AllowedNetworks []string
+	// TrustedProxies, in CIDR notation, are the networks of the upstream proxies
+	// that send a PROXY protocol header, version 1 or 2, with the address of the
+	// client. Connections from other addresses are served as direct ones.
+	TrustedProxies []string
+}
+
+type listener struct {
+	network      string
+	addr         *net.TCPAddr
+	loginTimeout time.Duration
+	queueTimeout time.Duration
+	idleTimeout  time.Duration
+	allowed      []*net.IPNet
+	proxies      []*net.IPNet
+}
+
+// newListener applies the defaults of c to lc.
+func newListener(lc ListenerConfig, c Config) (*listener, error) {
+	switch lc.Network {
+	case "":
+		lc.Network = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("invalid network %q", lc.Network)
+	}
+	addr, err := net.ResolveTCPAddr(lc.Network, lc.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.LoginTimeout < 0 {
+		return nil, errors.New("login timeout must not be negative")
+	}
+	if lc.LoginTimeout == 0 {
+		lc.LoginTimeout = c.LoginTimeout
+	}
+	if lc.QueueTimeout < 0 {
+		return nil, errors.New("queue timeout must not be negative")
+	}
+	if lc.QueueTimeout == 0 {
+		lc.QueueTimeout = c.QueueTimeout
+	}
+	if lc.IdleTimeout < 0 {
+		return nil, errors.New("idle timeout must not be negative")
+	}
+	if lc.IdleTimeout == 0 {
+		lc.IdleTimeout = c.IdleTimeout
+	}
+
+	allowed, err := parseCIDRs(lc.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies, err := parseCIDRs(lc.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &listener{
+		network:      lc.Network,
+		addr:         addr,
+		loginTimeout: lc.LoginTimeout,
+		queueTimeout: lc.QueueTimeout,
+		idleTimeout:  lc.IdleTimeout,
+		allowed:      allowed,
+		proxies:      proxies,
+	}, nil
+}
+
+// trustsProxy reports whether a connection from ip starts with a PROXY protocol
+// header.
+func (l *listener) trustsProxy(ip net.IP) bool {
+	return containsIP(l.proxies, ip)
+}
+
+// allows reports whether the client at ip can connect through the listener.
+func (l *listener) allows(ip net.IP) bool {
+	return len(l.allowed) == 0 || containsIP(l.allowed, ip)
+}
@@ -0,0 +1,373 @@
+package retrologin
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+	"github.com/kralamoure/retroproto/msgcli"
+
+	"github.com/kralamoure/retrologin/credential"
+)
+
+func newLoginTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	hash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+	_, err = storage.CreateUser(ctx, dofus.User{Id: "1", Nickname: "Existing", Hash: dofustyp.Hash(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.CreateAccount(ctx, dofus.Account{Id: "1", UserId: "1", Name: "existing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("password123"))
+	_, err = storage.CreateUser(ctx, dofus.User{
+		Id:       "2",
+		Nickname: "Legacy",
+		Email:    "legacy@example.com",
+		Hash:     dofustyp.Hash(hex.EncodeToString(sum[:])),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.CreateAccount(ctx, dofus.Account{Id: "2", UserId: "2", Name: "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.CreateUser(ctx, dofus.User{
+		Id:       "3",
+		Nickname: "Corrupt",
+		Email:    "corrupt@example.com",
+		Hash:     "not a hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.CreateAccount(ctx, dofus.Account{Id: "3", UserId: "3", Name: "corrupt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svr, err := NewServer(Config{
+		Addr:    "127.0.0.1:0",
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svr
+}
+
+// tryLogin logs in with username and password through a loopback connection,
+// returning what the client received, how long it took and the error of the
+// login.
+// addLoginTestHosts adds an online game server to the hosts of svr, which a
+// successful login sends to the client.
+func addLoginTestHosts(t *testing.T, svr *Server) {
+	t.Helper()
+
+	err := svr.storage.(*MemoryStorage).CreateGameServer(context.Background(), retro.GameServer{
+		Id:    601,
+		Host:  "127.0.0.1",
+		Port:  "5556",
+		State: retrotyp.GameServerStateOnline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := svr.fetchHosts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr.hosts.Store(hosts)
+}
+
+func tryLogin(t *testing.T, svr *Server, username, password string) (string, time.Duration, error) {
+	t.Helper()
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := ln.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt, err := randomSalt(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := credential.EncryptedPassword(password, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess := &session{
+		svr:     svr,
+		lis:     svr.listeners[0],
+		conn:    conn,
+		salt:    salt,
+		version: msgcli.AccountVersion{Major: 1, Minor: 29, Patch: 1},
+		credential: msgcli.AccountCredential{
+			Username:     username,
+			Hash:         hash,
+			CryptoMethod: CryptoMethodSalt,
+		},
+	}
+
+	start := time.Now()
+	auth, loginErr := sess.authenticate(context.Background())
+	if loginErr == nil {
+		loginErr = sess.login(context.Background(), auth)
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+
+	received, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(received), elapsed, loginErr
+}
+
+func TestLoginUnknownAccountIsUniform(t *testing.T) {
+	svr := newLoginTestServer(t)
+
+	const runs = 5
+	var unknownDurs, wrongDurs, legacyDurs []time.Duration
+
+	for i := 0; i < runs; i++ {
+		unknownReceived, unknownDur, unknownErr := tryLogin(t, svr, "unknown", "password456")
+		wrongReceived, wrongDur, wrongErr := tryLogin(t, svr, "existing", "password456")
+		legacyReceived, legacyDur, legacyErr := tryLogin(t, svr, "legacy", "password456")
+
+		if !errors.Is(unknownErr, errInvalidRequest) || !errors.Is(wrongErr, errInvalidRequest) ||
+			!errors.Is(legacyErr, errInvalidRequest) {
+			t.Fatalf("login errors: unknown account: %v, wrong password: %v, wrong legacy password: %v",
+				unknownErr, wrongErr, legacyErr)
+		}
+		if unknownReceived != wrongReceived || unknownReceived != legacyReceived {
+			t.Fatalf("received: unknown account: %q, wrong password: %q, wrong legacy password: %q",
+				unknownReceived, wrongReceived, legacyReceived)
+		}
+
+		unknownDurs = append(unknownDurs, unknownDur)
+		wrongDurs = append(wrongDurs, wrongDur)
+		legacyDurs = append(legacyDurs, legacyDur)
+	}
+
+	unknownMedian, wrongMedian, legacyMedian := median(unknownDurs), median(wrongDurs), median(legacyDurs)
+	if unknownMedian < wrongMedian/2 || wrongMedian < unknownMedian/2 ||
+		unknownMedian < legacyMedian/2 || legacyMedian < unknownMedian/2 {
+		t.Errorf("median durations: unknown account: %s, wrong password: %s, wrong legacy password: %s",
+			unknownMedian, wrongMedian, legacyMedian)
+	}
+}
+
+func TestLoginWrongPasswordIsRecorded(t *testing.T) {
+	svr := newLoginTestServer(t)
+
+	tryLogin(t, svr, "unknown", "password456")
+	tryLogin(t, svr, "existing", "password456")
+
+	keys := []string{
+		accountAttemptsKey("unknown"),
+		accountIPAttemptsKey("unknown", "127.0.0.1"),
+		accountAttemptsKey("existing"),
+		accountIPAttemptsKey("existing", "127.0.0.1"),
+	}
+	for _, key := range keys {
+		attempts, err := svr.loginAttempts.LoginAttempts(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts.Failures != 1 {
+			t.Errorf("failures of %q: got %d, want 1", key, attempts.Failures)
+		}
+	}
+}
+
+func TestAccountLockoutPerIP(t *testing.T) {
+	svr := newLoginTestServer(t)
+	ctx := context.Background()
+
+	for i := 0; i <= svr.accountLockout.maxFailures; i++ {
+		err := svr.addFailedLogin(ctx, "192.0.2.1", "existing")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lockout, err := svr.lockout(ctx, "192.0.2.1", "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout <= 0 {
+		t.Errorf("lockout of the failing IP address = %s, want more than 0", lockout)
+	}
+	lockout, err = svr.lockout(ctx, "192.0.2.2", "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout != 0 {
+		t.Errorf("lockout of another IP address = %s, want 0", lockout)
+	}
+}
+
+func TestAccountDelay(t *testing.T) {
+	svr := newLoginTestServer(t)
+	addLoginTestHosts(t, svr)
+	const delay = 200 * time.Millisecond
+	svr.accountDelay.dur = delay
+	svr.accountDelay.maxDur = delay
+	ctx := context.Background()
+
+	// Failures from as many IP addresses lock none of them out of the account,
+	// but they are all counted for it.
+	for i := 0; i <= svr.accountDelay.maxFailures; i++ {
+		err := svr.addFailedLogin(ctx, fmt.Sprintf("192.0.2.%d", i+1), "existing")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lockout, err := svr.lockout(ctx, "127.0.0.1", "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout != 0 {
+		t.Errorf("lockout of the owner = %s, want 0", lockout)
+	}
+	start := time.Now()
+	d, err := svr.loginDelay(ctx, "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d <= 0 {
+		t.Errorf("login delay of the account = %s, want more than 0", d)
+	}
+
+	// The owner still logs in, once the delay is over.
+	received, _, err := tryLogin(t, svr, "existing", "password123")
+	if err != nil {
+		t.Fatalf("login error: %v, received %q", err, received)
+	}
+	if elapsed := time.Since(start); elapsed < d {
+		t.Errorf("login took %s, less than the %s delay", elapsed, d)
+	}
+
+	d, err = svr.loginDelay(ctx, "existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 0 {
+		t.Errorf("login delay after a successful login = %s, want 0", d)
+	}
+}
+
+func TestLoginUnknownHashFormat(t *testing.T) {
+	svr := newLoginTestServer(t)
+
+	received, _, err := tryLogin(t, svr, "corrupt", "password123")
+	if !errors.Is(err, errInvalidRequest) {
+		t.Fatalf("login error: %v", err)
+	}
+	wrongReceived, _, _ := tryLogin(t, svr, "existing", "password456")
+	if received != wrongReceived {
+		t.Errorf("received: unknown hash format: %q, wrong password: %q", received, wrongReceived)
+	}
+}
+
+func TestLoginWaitsForPasswordPool(t *testing.T) {
+	defer func(d time.Duration) { queryTimeout = d }(queryTimeout)
+	queryTimeout = 50 * time.Millisecond
+	const busy = 200 * time.Millisecond
+
+	tests := []struct {
+		name string
+		wait time.Duration
+	}{
+		// The pool is freed within the wait, after the queries timed out.
+		{"wait longer than the query timeout", 1 * time.Second},
+		// The pool is still busy after several waits.
+		{"wait shorter than the busy pool", 20 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svr := newLoginTestServer(t)
+			addLoginTestHosts(t, svr)
+			svr.passwords = newPasswordPool(1, test.wait)
+			svr.passwords.slots <- struct{}{}
+			time.AfterFunc(busy, svr.passwords.release)
+
+			received, elapsed, err := tryLogin(t, svr, "existing", "password123")
+			if err != nil {
+				t.Fatalf("login error: %v, received %q", err, received)
+			}
+			if elapsed < busy {
+				t.Errorf("login took %s, less than the %s the pool was busy", elapsed, busy)
+			}
+		})
+	}
+}
+
+func TestNewServerHashParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params argon2id.Params
+	}{
+		{"zero iterations", argon2id.Params{Memory: 8, Parallelism: 1, SaltLength: 16, KeyLength: 32}},
+		{"zero parallelism", argon2id.Params{Memory: 8, Iterations: 1, SaltLength: 16, KeyLength: 32}},
+		{"zero salt length", argon2id.Params{Memory: 8, Iterations: 1, Parallelism: 1, KeyLength: 32}},
+		{"zero key length", argon2id.Params{Memory: 8, Iterations: 1, Parallelism: 1, SaltLength: 16}},
+	}
+
+	for _, test := range tests {
+		params := test.params
+		_, err := NewServer(Config{
+			Addr:       "127.0.0.1:0",
+			Storage:    NewMemoryStorage(),
+			HashParams: &params,
+		})
+		if err == nil {
+			t.Errorf("%s: NewServer did not return an error", test.name)
+		}
+	}
+}
+
+func median(durs []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,326 @@
+package retrologin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro"
+)
+
+// MemoryStorage is a Storage that keeps everything in memory, for tests and local
+// development. It also implements UserHashUpdater.
+type MemoryStorage struct {
+	mu          sync.Mutex
+	accounts    map[string]dofus.Account
+	users       map[string]dofus.User
+	characters  map[int]retro.Character
+	gameServers map[int]retro.GameServer
+	tickets     map[string]retro.Ticket
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		accounts:    make(map[string]dofus.Account),
+		users:       make(map[string]dofus.User),
+		characters:  make(map[int]retro.Character),
+		gameServers: make(map[int]retro.GameServer),
+		tickets:     make(map[string]retro.Ticket),
+	}
+}
+
+// CreateUser adds a user, with a random ID if it has none.
+func (s *MemoryStorage) CreateUser(ctx context.Context, user dofus.User) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.Id == "" {
+		id, err := randomUUID()
+		if err != nil {
+			return "", err
+		}
+		user.Id = id
+	}
+	if _, ok := s.users[user.Id]; ok {
+		return "", dofus.ErrAlreadyExists
+	}
+	for _, other := range s.users {
+		if strings.EqualFold(string(other.Nickname), string(user.Nickname)) {
+			return "", dofus.ErrUserNicknameAlreadyExists
+		}
+		if strings.EqualFold(string(other.Email), string(user.Email)) {
+			return "", dofus.ErrUserEmailAlreadyExists
+		}
+	}
+
+	s.users[user.Id] = user
+	return user.Id, nil
+}
+
+// CreateAccount adds an account of an existing user, with a random ID if it has
+// none.
+func (s *MemoryStorage) CreateAccount(ctx context.Context, account dofus.Account) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[account.UserId]; !ok {
+		return "", fmt.Errorf("user %q: %w", account.UserId, dofus.ErrNotFound)
+	}
+	if account.Id == "" {
+		id, err := randomUUID()
+		if err != nil {
+			return "", err
+		}
+		account.Id = id
+	}
+	if _, ok := s.accounts[account.Id]; ok {
+		return "", dofus.ErrAlreadyExists
+	}
+	for _, other := range s.accounts {
+		if strings.EqualFold(string(other.Name), string(account.Name)) {
+			return "", dofus.ErrAccountNameAlreadyExists
+		}
+	}
+
+	s.accounts[account.Id] = account
+	return account.Id, nil
+}
+
+// CreateGameServer adds a game server.
+func (s *MemoryStorage) CreateGameServer(ctx context.Context, gameServer retro.GameServer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.gameServers[gameServer.Id]; ok {
+		return retro.ErrAlreadyExists
+	}
+	for _, other := range s.gameServers {
+		if other.Host == gameServer.Host && other.Port == gameServer.Port {
+			return retro.ErrGameServerHostAndPortAlreadyExist
+		}
+	}
+
+	s.gameServers[gameServer.Id] = gameServer
+	return nil
+}
+
+// CreateCharacter adds a character, with the next free ID if it has none.
+func (s *MemoryStorage) CreateCharacter(ctx context.Context, character retro.Character) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if character.Id == 0 {
+		for id := range s.characters {
+			if id > character.Id {
+				character.Id = id
+			}
+		}
+		character.Id++
+	}
+	if _, ok := s.characters[character.Id]; ok {
+		return 0, retro.ErrAlreadyExists
+	}
+	for _, other := range s.characters {
+		if other.GameServerId == character.GameServerId &&
+			strings.EqualFold(string(other.Name), string(character.Name)) {
+			return 0, retro.ErrCharacterNameAndGameServerIdAlreadyExist
+		}
+	}
+
+	s.characters[character.Id] = character
+	return character.Id, nil
+}
+
+func (s *MemoryStorage) Account(ctx context.Context, id string) (dofus.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return dofus.Account{}, dofus.ErrNotFound
+	}
+	return account, nil
+}
+
+func (s *MemoryStorage) AccountByName(ctx context.Context, name string) (dofus.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, account := range s.accounts {
+		if strings.EqualFold(string(account.Name), name) {
+			return account, nil
+		}
+	}
+	return dofus.Account{}, dofus.ErrNotFound
+}
+
+func (s *MemoryStorage) AccountsByUserId(ctx context.Context, userId string) (map[string]dofus.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := make(map[string]dofus.Account)
+	for id, account := range s.accounts {
+		if account.UserId == userId {
+			accounts[id] = account
+		}
+	}
+	return accounts, nil
+}
+
+func (s *MemoryStorage) User(ctx context.Context, id string) (dofus.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return dofus.User{}, dofus.ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStorage) UserByNickname(ctx context.Context, nickname string) (dofus.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if strings.EqualFold(string(user.Nickname), nickname) {
+			return user, nil
+		}
+	}
+	return dofus.User{}, dofus.ErrNotFound
+}
+
+func (s *MemoryStorage) SetUserHash(ctx context.Context, id string, hash dofustyp.Hash) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return dofus.ErrNotFound
+	}
+	user.Hash = hash
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStorage) AllCharactersByAccountId(ctx context.Context, accountId string) (map[int]retro.Character, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	characters := make(map[int]retro.Character)
+	for id, character := range s.characters {
+		if character.AccountId == accountId {
+			characters[id] = character
+		}
+	}
+	return characters, nil
+}
+
+func (s *MemoryStorage) GameServers(ctx context.Context) (map[int]retro.GameServer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gameServers := make(map[int]retro.GameServer, len(s.gameServers))
+	for id, gameServer := range s.gameServers {
+		gameServers[id] = gameServer
+	}
+	return gameServers, nil
+}
+
+func (s *MemoryStorage) GameServer(ctx context.Context, id int) (retro.GameServer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gameServer, ok := s.gameServers[id]
+	if !ok {
+		return retro.GameServer{}, retro.ErrNotFound
+	}
+	return gameServer, nil
+}
+
+func (s *MemoryStorage) CreateTicket(ctx context.Context, ticket retro.Ticket) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, other := range s.tickets {
+		if other.AccountId == ticket.AccountId {
+			delete(s.tickets, id)
+		}
+	}
+
+	id, err := randomUUID()
+	if err != nil {
+		return "", err
+	}
+	ticket.Id = id
+	ticket.Created = time.Now().UTC()
+	s.tickets[id] = ticket
+
+	return id, nil
+}
+
+func (s *MemoryStorage) DeleteTickets(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for id, ticket := range s.tickets {
+		if !ticket.Created.After(before) {
+			delete(s.tickets, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStorage) UseTicket(ctx context.Context, id string) (retro.Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ticket, ok := s.tickets[id]
+	if !ok {
+		return retro.Ticket{}, retro.ErrNotFound
+	}
+	delete(s.tickets, id)
+	return ticket, nil
+}
+
+func (s *MemoryStorage) DeleteTicketByAccountId(ctx context.Context, accountId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, ticket := range s.tickets {
+		if ticket.AccountId == accountId {
+			delete(s.tickets, id)
+			return nil
+		}
+	}
+	return retro.ErrNotFound
+}
+
+func (s *MemoryStorage) Tickets(ctx context.Context) (map[string]retro.Ticket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tickets := make(map[string]retro.Ticket, len(s.tickets))
+	for id, ticket := range s.tickets {
+		tickets[id] = ticket
+	}
+	return tickets, nil
+}
+
+// randomUUID returns a random (version 4) UUID, like the IDs of the PostgreSQL
+// storage.
+func randomUUID() (string, error) {
+	b, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,90 @@
+package retrologin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+var errPasswordPoolBusy = errors.New("password verification pool is busy")
+
+// PasswordPoolStats are the metrics of the pool that bounds the number of
+// password hashes verified concurrently.
+type PasswordPoolStats struct {
+	Size     int
+	InUse    int
+	Waiting  int
+	Acquired uint64
+	TimedOut uint64
+	WaitTime time.Duration
+}
+
+type passwordPool struct {
+	slots   chan struct{}
+	maxWait time.Duration
+
+	waiting  atomic.Int64
+	acquired atomic.Uint64
+	timedOut atomic.Uint64
+	waitTime atomic.Duration
+}
+
+func newPasswordPool(size int, maxWait time.Duration) *passwordPool {
+	return &passwordPool{
+		slots:   make(chan struct{}, size),
+		maxWait: maxWait,
+	}
+}
+
+// do calls f once a slot of the pool is free. It returns errPasswordPoolBusy if
+// none was freed within the maximum wait.
+func (p *passwordPool) do(ctx context.Context, f func()) error {
+	err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.release()
+
+	f()
+	return nil
+}
+
+func (p *passwordPool) acquire(ctx context.Context) error {
+	start := time.Now()
+	p.waiting.Inc()
+	defer func() {
+		p.waiting.Dec()
+		p.waitTime.Add(time.Since(start))
+	}()
+
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+		p.acquired.Inc()
+		return nil
+	case <-timer.C:
+		p.timedOut.Inc()
+		return errPasswordPoolBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *passwordPool) release() {
+	<-p.slots
+}
+
+func (p *passwordPool) stats() PasswordPoolStats {
+	return PasswordPoolStats{
+		Size:     cap(p.slots),
+		InUse:    len(p.slots),
+		Waiting:  int(p.waiting.Load()),
+		Acquired: p.acquired.Load(),
+		TimedOut: p.timedOut.Load(),
+		WaitTime: p.waitTime.Load(),
+	}
+}
@@ -0,0 +1,195 @@
+package retrologin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyHeaderTimeout is how long a trusted proxy has to send the PROXY protocol
+// header after connecting.
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyConn is a connection from a trusted proxy, whose remote address is the one
+// of the client in the PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *proxyConn) SetKeepAlive(keepalive bool) error {
+	if kaConn, ok := c.Conn.(keepAliveConn); ok {
+		return kaConn.SetKeepAlive(keepalive)
+	}
+	return nil
+}
+
+func (c *proxyConn) SetKeepAlivePeriod(d time.Duration) error {
+	if kaConn, ok := c.Conn.(keepAliveConn); ok {
+		return kaConn.SetKeepAlivePeriod(d)
+	}
+	return nil
+}
+
+// readProxyHeader reads the PROXY protocol header, version 1 or 2, at the start
+// of conn. Headers of the LOCAL command, or of other families than TCP over IPv4
+// and IPv6, keep the address of the proxy.
+func readProxyHeader(conn net.Conn) (*proxyConn, error) {
+	err := conn.SetReadDeadline(time.Now().UTC().Add(proxyHeaderTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	pc := &proxyConn{
+		Conn:       conn,
+		r:          r,
+		remoteAddr: conn.RemoteAddr(),
+	}
+
+	// Version 1 headers start with "PROXY ", version 2 ones with a 12-byte
+	// signature. Only as many bytes as the version needs are waited for.
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	var addr net.Addr
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		addr, err = readProxyHeaderV1(r)
+	} else {
+		var sig []byte
+		sig, err = r.Peek(len(proxyV2Signature))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(sig, proxyV2Signature) {
+			return nil, errInvalidProxyHeader
+		}
+		addr, err = readProxyHeaderV2(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if addr != nil {
+		pc.remoteAddr = addr
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return pc, nil
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// The longest header is 107 bytes long, line ending included.
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 {
+			return nil, errInvalidProxyHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, errInvalidProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errInvalidProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, errInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, errInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errInvalidProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version", errInvalidProxyHeader)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: unsupported command", errInvalidProxyHeader)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default:
+		return nil, nil
+	}
+}
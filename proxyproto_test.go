@@ -0,0 +1,34 @@
+package retrologin
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		addr   string
+	}{
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 56324 5555\r\n", "192.0.2.1:56324"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 56324 5555\r\n", "[2001:db8::1]:56324"},
+		// The shortest header, followed by nothing until the server answers.
+		{"PROXY UNKNOWN\r\n", "pipe"},
+		{"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c\xc0\x00\x02\x01\xc0\x00\x02\x02\xdc\x04\x15\xb3", "192.0.2.1:56324"},
+	}
+
+	for _, test := range tests {
+		conn, proxy := net.Pipe()
+		go proxy.Write([]byte(test.header))
+
+		pc, err := readProxyHeader(conn)
+		if err != nil {
+			t.Errorf("header %q: %v", test.header, err)
+		} else if got := pc.RemoteAddr().String(); got != test.addr {
+			t.Errorf("header %q: remote address = %s, want %s", test.header, got, test.addr)
+		}
+
+		conn.Close()
+		proxy.Close()
+	}
+}
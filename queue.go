@@ -0,0 +1,204 @@
+package retrologin
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+type loginQueue struct {
+	id               int
+	concurrency      int
+	subscriberWeight int
+
+	mu          sync.Mutex
+	active      int
+	subscribers *list.List
+	others      *list.List
+	// streak is the number of subscribers granted a login turn in a row while
+	// non-subscribers were waiting.
+	streak  int
+	entries map[*session]*queueEntry
+}
+
+type queueEntry struct {
+	elem       *list.Element
+	subscriber bool
+	granted    bool
+}
+
+type queueUpdate struct {
+	sess *session
+	msg  msgsvr.AccountNewQueue
+}
+
+func newLoginQueue(id, concurrency, subscriberWeight int) *loginQueue {
+	return &loginQueue{
+		id:               id,
+		concurrency:      concurrency,
+		subscriberWeight: subscriberWeight,
+		subscribers:      list.New(),
+		others:           list.New(),
+		entries:          make(map[*session]*queueEntry),
+	}
+}
+
+// join appends sess to the end of its lane, unless it is already in the queue,
+// and grants login turns to as many waiting sessions as the concurrency allows.
+func (q *loginQueue) join(sess *session, subscriber bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.entries[sess]; ok {
+		return
+	}
+	q.entries[sess] = &queueEntry{
+		elem:       q.lane(subscriber).PushBack(sess),
+		subscriber: subscriber,
+	}
+
+	q.dispatch()
+}
+
+// leave removes sess from the queue and releases its login turn if it had been
+// granted one. It is safe to call it more than once.
+func (q *loginQueue) leave(sess *session) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.entries[sess]
+	if !ok {
+		return
+	}
+	delete(q.entries, sess)
+
+	if entry.granted {
+		q.active--
+	} else {
+		q.lane(entry.subscriber).Remove(entry.elem)
+	}
+
+	q.dispatch()
+}
+
+// status returns the queue message for sess. A session that has been granted its
+// login turn but has not left the queue yet is reported at the first position.
+func (q *loginQueue) status(sess *session) (msgsvr.AccountNewQueue, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.entries[sess]
+	if !ok {
+		return msgsvr.AccountNewQueue{}, false
+	}
+
+	position := 1
+	if !entry.granted {
+		for _, v := range q.order() {
+			if v == sess {
+				break
+			}
+			position++
+		}
+	}
+
+	return q.message(position, entry.subscriber), true
+}
+
+// updates returns the queue message of every session that is still waiting for
+// its login turn.
+func (q *loginQueue) updates() []queueUpdate {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	order := q.order()
+	updates := make([]queueUpdate, len(order))
+	for i, sess := range order {
+		updates[i] = queueUpdate{
+			sess: sess,
+			msg:  q.message(i+1, q.entries[sess].subscriber),
+		}
+	}
+
+	return updates
+}
+
+func (q *loginQueue) message(position int, subscriber bool) msgsvr.AccountNewQueue {
+	m := msgsvr.AccountNewQueue{
+		Position:    position,
+		TotalAbo:    q.subscribers.Len(),
+		TotalNonAbo: q.others.Len(),
+		Subscriber:  subscriber,
+		QueueId:     q.id,
+	}
+	if m.TotalAbo+m.TotalNonAbo < position {
+		if subscriber {
+			m.TotalAbo = position - m.TotalNonAbo
+		} else {
+			m.TotalNonAbo = position - m.TotalAbo
+		}
+	}
+	return m
+}
+
+// order returns the waiting sessions in the order they would be granted their
+// login turns if nobody joined or left the queue.
+func (q *loginQueue) order() []*session {
+	order := make([]*session, 0, q.subscribers.Len()+q.others.Len())
+
+	sub, other := q.subscribers.Front(), q.others.Front()
+	streak := q.streak
+	for sub != nil || other != nil {
+		if q.pickSubscriber(sub != nil, other != nil, streak) {
+			order = append(order, sub.Value.(*session))
+			sub = sub.Next()
+			streak++
+		} else {
+			order = append(order, other.Value.(*session))
+			other = other.Next()
+			streak = 0
+		}
+	}
+
+	return order
+}
+
+func (q *loginQueue) dispatch() {
+	for q.active < q.concurrency {
+		var lane *list.List
+		if q.pickSubscriber(q.subscribers.Len() > 0, q.others.Len() > 0, q.streak) {
+			lane = q.subscribers
+			q.streak++
+		} else if q.others.Len() > 0 {
+			lane = q.others
+			q.streak = 0
+		} else {
+			return
+		}
+
+		sess := lane.Remove(lane.Front()).(*session)
+		q.entries[sess].granted = true
+		q.active++
+		close(sess.loginTurn)
+	}
+}
+
+// pickSubscriber reports whether the next login turn goes to the subscriber lane,
+// which is favored subscriberWeight times for every turn of the other lane.
+func (q *loginQueue) pickSubscriber(subscribers, others bool, streak int) bool {
+	if !subscribers {
+		return false
+	}
+	if !others {
+		return true
+	}
+	return streak < q.subscriberWeight
+}
+
+func (q *loginQueue) lane(subscriber bool) *list.List {
+	if subscriber {
+		return q.subscribers
+	}
+	return q.others
+}
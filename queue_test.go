@@ -0,0 +1,140 @@
+package retrologin
+
+import (
+	"testing"
+
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+func newQueueTestSessions(n int) []*session {
+	sessions := make([]*session, n)
+	for i := range sessions {
+		sessions[i] = &session{loginTurn: make(chan struct{})}
+	}
+	return sessions
+}
+
+func granted(sess *session) bool {
+	select {
+	case <-sess.loginTurn:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestLoginQueueOrder(t *testing.T) {
+	// Without concurrency, nobody is granted a login turn.
+	q := newLoginQueue(7, 0, 2)
+	others := newQueueTestSessions(3)
+	subscribers := newQueueTestSessions(4)
+	for _, sess := range others {
+		q.join(sess, false)
+	}
+	for _, sess := range subscribers {
+		q.join(sess, true)
+	}
+
+	want := []*session{subscribers[0], subscribers[1], others[0], subscribers[2], subscribers[3], others[1], others[2]}
+	order := q.order()
+	if len(order) != len(want) {
+		t.Fatalf("order has %d sessions, want %d", len(order), len(want))
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("order[%d] is not the expected session", i)
+		}
+	}
+
+	updates := q.updates()
+	if len(updates) != len(want) {
+		t.Fatalf("updates has %d sessions, want %d", len(updates), len(want))
+	}
+	for i, update := range updates {
+		if update.sess != want[i] || update.msg.Position != i+1 {
+			t.Errorf("updates[%d] = %+v, want the expected session at position %d", i, update.msg, i+1)
+		}
+	}
+}
+
+func TestLoginQueueStatus(t *testing.T) {
+	q := newLoginQueue(7, 0, 2)
+	others := newQueueTestSessions(2)
+	subscribers := newQueueTestSessions(3)
+	for _, sess := range others {
+		q.join(sess, false)
+	}
+	for _, sess := range subscribers {
+		q.join(sess, true)
+	}
+
+	tests := []struct {
+		sess *session
+		want msgsvr.AccountNewQueue
+	}{
+		{subscribers[0], msgsvr.AccountNewQueue{Position: 1, TotalAbo: 3, TotalNonAbo: 2, Subscriber: true, QueueId: 7}},
+		{others[0], msgsvr.AccountNewQueue{Position: 3, TotalAbo: 3, TotalNonAbo: 2, QueueId: 7}},
+		{subscribers[2], msgsvr.AccountNewQueue{Position: 4, TotalAbo: 3, TotalNonAbo: 2, Subscriber: true, QueueId: 7}},
+		{others[1], msgsvr.AccountNewQueue{Position: 5, TotalAbo: 3, TotalNonAbo: 2, QueueId: 7}},
+	}
+	for i, test := range tests {
+		got, ok := q.status(test.sess)
+		if !ok || got != test.want {
+			t.Errorf("status of session %d = %+v, %t, want %+v", i, got, ok, test.want)
+		}
+	}
+
+	q.leave(subscribers[0])
+	got, _ := q.status(others[1])
+	want := msgsvr.AccountNewQueue{Position: 4, TotalAbo: 2, TotalNonAbo: 2, QueueId: 7}
+	if got != want {
+		t.Errorf("status after a subscriber left = %+v, want %+v", got, want)
+	}
+
+	_, ok := q.status(subscribers[0])
+	if ok {
+		t.Error("status of a session that left the queue is ok")
+	}
+}
+
+func TestLoginQueueDispatch(t *testing.T) {
+	q := newLoginQueue(7, 1, 2)
+	others := newQueueTestSessions(3)
+	subscribers := newQueueTestSessions(3)
+
+	q.join(others[0], false)
+	if !granted(others[0]) {
+		t.Fatal("the first session was not granted its login turn")
+	}
+	// A granted session is reported first, and counted in its lane.
+	got, _ := q.status(others[0])
+	want := msgsvr.AccountNewQueue{Position: 1, TotalNonAbo: 1, QueueId: 7}
+	if got != want {
+		t.Errorf("status of the granted session = %+v, want %+v", got, want)
+	}
+
+	q.join(others[1], false)
+	q.join(others[2], false)
+	for _, sess := range subscribers {
+		q.join(sess, true)
+	}
+
+	// Subscribers are let in twice for every non-subscriber.
+	turns := []*session{subscribers[0], subscribers[1], others[1], subscribers[2], others[2]}
+	current := others[0]
+	for i, next := range turns {
+		if granted(next) {
+			t.Fatalf("turn %d was granted before the previous session left", i)
+		}
+		q.leave(current)
+		if !granted(next) {
+			t.Fatalf("turn %d was not granted to the expected session", i)
+		}
+		current = next
+	}
+	q.leave(current)
+
+	if q.active != 0 || len(q.entries) != 0 {
+		t.Errorf("queue still has %d active and %d entries", q.active, len(q.entries))
+	}
+}
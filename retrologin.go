@@ -3,52 +3,353 @@ package retrologin
 
 import (
 	"errors"
-	"net"
+	"fmt"
+	"runtime"
 	"time"
 
+	"github.com/alexedwards/argon2id"
 	"github.com/happybydefault/logging"
-	"github.com/kralamoure/dofus/dofussvc"
-	"github.com/kralamoure/retro/retrosvc"
 )
 
 type Config struct {
-	Addr        string
-	ConnTimeout time.Duration
-	TicketDur   time.Duration
-	Dofus       *dofussvc.Service
-	Retro       *retrosvc.Service
-	Logger      logging.Logger
+	Addr string
+	// Network is the network of Addr, "tcp4", "tcp6" or "tcp". With the default,
+	// "tcp", the network follows the address, and a wildcard address listens on
+	// both IPv4 and IPv6.
+	Network string
+	// Listeners are the addresses to listen on instead of Addr, each with its own
+	// settings. They are mutually exclusive with Addr and Network.
+	Listeners []ListenerConfig
+	// LoginTimeout is how long a client has to send its version and credential
+	// after connecting, QueueTimeout how long it can wait in the login queue, and
+	// IdleTimeout how long it can stay inactive once logged in. Zero means no
+	// timeout.
+	LoginTimeout time.Duration
+	QueueTimeout time.Duration
+	IdleTimeout  time.Duration
+	// MaxConns and MaxConnsPerIP cap the number of client connections, in total
+	// and per IP address. Zero means no cap.
+	MaxConns      int
+	MaxConnsPerIP int
+	// ConnLimitExempt are networks, in CIDR notation, whose addresses are not
+	// subject to MaxConnsPerIP, like those of internet cafés behind a NAT.
+	ConnLimitExempt []string
+	// AllowListFile and DenyListFile are files of networks, one CIDR or IP
+	// address per line, whose clients are allowed or denied. If there is an allow
+	// list, clients must be in it and not in the deny list. The files are reloaded
+	// when they change, or with Server.ReloadIPFilter.
+	AllowListFile string
+	DenyListFile  string
+	TicketDur     time.Duration
+	// MaxLogins is the maximum number of logins processed concurrently. Clients
+	// beyond it wait in the login queue, which they join once their password has
+	// been checked, so that subscribers can be told apart. Defaults to the number
+	// of CPUs.
+	MaxLogins int
+	QueueId   int
+	// SubscriberWeight is the number of subscribers let in for every
+	// non-subscriber when both are waiting in the login queue. Defaults to 3.
+	SubscriberWeight int
+	// CredentialDecoders registers decoders by crypto method, in addition to the
+	// built-in CryptoMethodSalt, which can also be overridden.
+	CredentialDecoders map[int]CredentialDecoder
+	// CryptoMethods are the crypto methods accepted in account credentials.
+	// Defaults to all of the registered ones.
+	CryptoMethods []int
+	// ClientVersions is a semantic version constraint on the clients allowed to
+	// log in. Defaults to DefaultClientVersions.
+	ClientVersions string
+	// DeniedClientVersions are builds, as sent by the client, that are refused
+	// even if they satisfy ClientVersions. A version without suffix, like
+	// "1.29.1", denies all of its builds, like "1.29.1e" and "1.29.1s", while one
+	// with a suffix only denies that build.
+	DeniedClientVersions []string
+	// MaxPasswordVerifications is the maximum number of password hashes verified
+	// concurrently. Defaults to the number of CPUs.
+	MaxPasswordVerifications int
+	// PasswordVerificationWait is how long a login waits for a password
+	// verification before trying again, its client waiting in the queue state
+	// meanwhile. Defaults to 1 second.
+	PasswordVerificationWait time.Duration
+	// HashVerifiers verify passwords against hashes of other formats than the
+	// built-in Argon2idHashes, BcryptHashes, SHA1Hashes and SaltedMD5Hashes.
+	// They take precedence over the built-in ones.
+	HashVerifiers []HashVerifier
+	// HashParams are the argon2id parameters of the hashes that replace legacy
+	// ones. Defaults to argon2id.DefaultParams.
+	//
+	// They are also those of the dummy hash that the passwords of unknown
+	// accounts are checked against. They are not read from the stored hashes, so
+	// they should match the parameters of most of them: otherwise, an unknown
+	// account takes a different time to be rejected than a wrong password, which
+	// tells it apart.
+	HashParams *argon2id.Params
+	// UserHashes stores the rehashed passwords. If nil, legacy hashes are kept.
+	UserHashes UserHashUpdater
+	// LoginAttempts records failed logins. Defaults to a
+	// MemoryLoginAttemptStore.
+	LoginAttempts LoginAttemptStore
+	// MaxFailedLoginsPerIP and MaxFailedLoginsPerAccount are the numbers of
+	// failed logins tolerated before locking out an IP address, or an account
+	// name from an IP address, for LockoutDur at first, doubling with each
+	// further failure up to MaxLockoutDur. They default to 20, 5, 1 minute and 1
+	// hour respectively.
+	MaxFailedLoginsPerIP      int
+	MaxFailedLoginsPerAccount int
+	LockoutDur                time.Duration
+	MaxLockoutDur             time.Duration
+	// AccountDelay and MaxAccountDelay slow down the logins of an account name
+	// once it has more than MaxFailedLoginsPerAccount failed logins from any IP
+	// address: a login waits until AccountDelay after the last failure at first,
+	// doubling with each further failure up to MaxAccountDelay. The account is
+	// never locked out this way, so that its owner can still log in. They default
+	// to 1 second and 30 seconds respectively.
+	AccountDelay    time.Duration
+	MaxAccountDelay time.Duration
+	// Bans looks up the bans of accounts and IP addresses. If nil, nothing is
+	// banned. New account bans are polled every 10 seconds, to kick the accounts
+	// and revoke their tickets.
+	Bans BanStore
+	// Takeover decides what happens when an account logs in while it is already
+	// logged in. Defaults to TakeoverKick.
+	Takeover TakeoverPolicy
+	// TakeoverGrace is how long an account is still considered logged in after
+	// its session disconnects, e.g. while it joins a game server. Zero means no
+	// grace window.
+	TakeoverGrace time.Duration
+	// Maintenance starts the server in maintenance mode. See
+	// Server.SetMaintenance.
+	Maintenance bool
+	// Storage is where the accounts, users, characters, game servers and tickets
+	// are. See NewServiceStorage and MemoryStorage.
+	Storage Storage
+	Logger  logging.Logger
 }
 
 func NewServer(c Config) (*Server, error) {
-	if c.ConnTimeout < 0 {
-		return nil, errors.New("connection timeout must not be negative")
+	if c.LoginTimeout < 0 {
+		return nil, errors.New("login timeout must not be negative")
+	}
+	if c.QueueTimeout < 0 {
+		return nil, errors.New("queue timeout must not be negative")
+	}
+	if c.IdleTimeout < 0 {
+		return nil, errors.New("idle timeout must not be negative")
+	}
+	if c.MaxConns < 0 {
+		return nil, errors.New("max connections must not be negative")
+	}
+	if c.MaxConnsPerIP < 0 {
+		return nil, errors.New("max connections per IP must not be negative")
+	}
+	connLimiter, err := newConnLimiter(c.MaxConns, c.MaxConnsPerIP, c.ConnLimitExempt)
+	if err != nil {
+		return nil, err
+	}
+	var ipFilter *ipFilter
+	if c.AllowListFile != "" || c.DenyListFile != "" {
+		ipFilter, err = newIPFilter(c.AllowListFile, c.DenyListFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 	if c.TicketDur < 0 {
 		return nil, errors.New("ticket duration must not be negative")
 	}
-	if c.Dofus == nil {
-		return nil, errors.New("nil dofus service")
+	if c.MaxLogins < 0 {
+		return nil, errors.New("max logins must not be negative")
+	}
+	if c.MaxLogins == 0 {
+		c.MaxLogins = runtime.NumCPU()
+	}
+	if c.SubscriberWeight < 0 {
+		return nil, errors.New("subscriber weight must not be negative")
+	}
+	if c.SubscriberWeight == 0 {
+		c.SubscriberWeight = 3
+	}
+	decoders := defaultCredentialDecoders()
+	for method, decoder := range c.CredentialDecoders {
+		if decoder == nil {
+			return nil, fmt.Errorf("nil credential decoder for crypto method %d", method)
+		}
+		decoders[method] = decoder
+	}
+	if c.CryptoMethods != nil {
+		accepted := make(map[int]CredentialDecoder)
+		for _, method := range c.CryptoMethods {
+			decoder, ok := decoders[method]
+			if !ok {
+				return nil, fmt.Errorf("no credential decoder for crypto method %d", method)
+			}
+			accepted[method] = decoder
+		}
+		decoders = accepted
+	}
+	versions, err := newVersionPolicy(c.ClientVersions, c.DeniedClientVersions)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxPasswordVerifications < 0 {
+		return nil, errors.New("max password verifications must not be negative")
+	}
+	if c.MaxPasswordVerifications == 0 {
+		c.MaxPasswordVerifications = runtime.NumCPU()
+	}
+	if c.PasswordVerificationWait < 0 {
+		return nil, errors.New("password verification wait must not be negative")
+	}
+	if c.PasswordVerificationWait == 0 {
+		c.PasswordVerificationWait = 1 * time.Second
+	}
+	for _, verifier := range c.HashVerifiers {
+		if verifier == nil {
+			return nil, errors.New("nil hash verifier")
+		}
+	}
+	if c.HashParams == nil {
+		c.HashParams = argon2id.DefaultParams
+	}
+	if c.HashParams.Iterations == 0 {
+		return nil, errors.New("hash iterations must not be zero")
+	}
+	if c.HashParams.Parallelism == 0 {
+		return nil, errors.New("hash parallelism must not be zero")
+	}
+	if c.HashParams.SaltLength == 0 {
+		return nil, errors.New("hash salt length must not be zero")
 	}
-	if c.Retro == nil {
-		return nil, errors.New("nil retro service")
+	if c.HashParams.KeyLength == 0 {
+		return nil, errors.New("hash key length must not be zero")
+	}
+	if c.MaxFailedLoginsPerIP < 0 {
+		return nil, errors.New("max failed logins per IP must not be negative")
+	}
+	if c.MaxFailedLoginsPerIP == 0 {
+		c.MaxFailedLoginsPerIP = 20
+	}
+	if c.MaxFailedLoginsPerAccount < 0 {
+		return nil, errors.New("max failed logins per account must not be negative")
+	}
+	if c.MaxFailedLoginsPerAccount == 0 {
+		c.MaxFailedLoginsPerAccount = 5
+	}
+	if c.LockoutDur < 0 {
+		return nil, errors.New("lockout duration must not be negative")
+	}
+	if c.LockoutDur == 0 {
+		c.LockoutDur = 1 * time.Minute
+	}
+	if c.MaxLockoutDur < 0 {
+		return nil, errors.New("max lockout duration must not be negative")
+	}
+	if c.MaxLockoutDur == 0 {
+		c.MaxLockoutDur = 1 * time.Hour
+	}
+	if c.MaxLockoutDur < c.LockoutDur {
+		return nil, errors.New("max lockout duration must not be shorter than lockout duration")
+	}
+	if c.AccountDelay < 0 {
+		return nil, errors.New("account delay must not be negative")
+	}
+	if c.AccountDelay == 0 {
+		c.AccountDelay = 1 * time.Second
+	}
+	if c.MaxAccountDelay < 0 {
+		return nil, errors.New("max account delay must not be negative")
+	}
+	if c.MaxAccountDelay == 0 {
+		c.MaxAccountDelay = 30 * time.Second
+	}
+	if c.MaxAccountDelay < c.AccountDelay {
+		return nil, errors.New("max account delay must not be shorter than account delay")
+	}
+	if c.LoginAttempts == nil {
+		ttl := c.MaxLockoutDur
+		if c.MaxAccountDelay > ttl {
+			ttl = c.MaxAccountDelay
+		}
+		c.LoginAttempts = NewMemoryLoginAttemptStore(2 * ttl)
+	}
+	switch c.Takeover {
+	case TakeoverKick, TakeoverReject, TakeoverCoexist:
+	default:
+		return nil, fmt.Errorf("invalid takeover policy %d", c.Takeover)
+	}
+	if c.TakeoverGrace < 0 {
+		return nil, errors.New("takeover grace must not be negative")
+	}
+	if c.Storage == nil {
+		return nil, errors.New("nil storage")
 	}
 	if c.Logger == nil {
 		c.Logger = logging.Noop{}
 	}
-	addr, err := net.ResolveTCPAddr("tcp4", c.Addr)
+	if len(c.Listeners) > 0 && (c.Addr != "" || c.Network != "") {
+		return nil, errors.New("listeners are mutually exclusive with address and network")
+	}
+	if len(c.Listeners) == 0 {
+		c.Listeners = []ListenerConfig{{Addr: c.Addr, Network: c.Network}}
+	}
+	listeners := make([]*listener, 0, len(c.Listeners))
+	for _, lc := range c.Listeners {
+		l, err := newListener(lc, c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener %q: %w", lc.Addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	dummyPassword, err := randomSalt(32)
+	if err != nil {
+		return nil, err
+	}
+	dummyHash, err := argon2id.CreateHash(dummyPassword, c.HashParams)
 	if err != nil {
 		return nil, err
 	}
 	s := &Server{
-		logger:             c.Logger,
-		addr:               addr,
-		connTimeout:        c.ConnTimeout,
-		ticketDur:          c.TicketDur,
-		dofus:              c.Dofus,
-		retro:              c.Retro,
-		sessions:           make(map[*session]struct{}),
-		sessionByAccountId: make(map[string]*session),
+		logger:    c.Logger,
+		listeners: listeners,
+		defaultListener: &listener{
+			loginTimeout: c.LoginTimeout,
+			queueTimeout: c.QueueTimeout,
+			idleTimeout:  c.IdleTimeout,
+		},
+		ticketDur:     c.TicketDur,
+		storage:       c.Storage,
+		ipFilter:      ipFilter,
+		connLimiter:   connLimiter,
+		queue:         newLoginQueue(c.QueueId, c.MaxLogins, c.SubscriberWeight),
+		versions:      versions,
+		loginAttempts: c.LoginAttempts,
+		dummyHash:     dummyHash,
+		passwords:     newPasswordPool(c.MaxPasswordVerifications, c.PasswordVerificationWait),
+		hashVerifiers: append(append([]HashVerifier(nil), c.HashVerifiers...), defaultHashVerifiers()...),
+		hashParams:    c.HashParams,
+		userHashes:    c.UserHashes,
+		ipLockout: lockoutPolicy{
+			maxFailures: c.MaxFailedLoginsPerIP,
+			dur:         c.LockoutDur,
+			maxDur:      c.MaxLockoutDur,
+		},
+		accountLockout: lockoutPolicy{
+			maxFailures: c.MaxFailedLoginsPerAccount,
+			dur:         c.LockoutDur,
+			maxDur:      c.MaxLockoutDur,
+		},
+		accountDelay: lockoutPolicy{
+			maxFailures: c.MaxFailedLoginsPerAccount,
+			dur:         c.AccountDelay,
+			maxDur:      c.MaxAccountDelay,
+		},
+		credentialDecoders:  decoders,
+		bans:                c.Bans,
+		takeover:            c.Takeover,
+		takeoverGrace:       c.TakeoverGrace,
+		sessions:            make(map[*session]struct{}),
+		sessionsByAccountId: make(map[string]map[*session]struct{}),
+		logouts:             make(map[string]logout),
 	}
+	s.maintenance.Store(c.Maintenance)
 	return s, nil
 }
@@ -0,0 +1,122 @@
+// Package retrologintest provides a login server with a known account, for the
+// end-to-end tests of retrologin and of its clients.
+package retrologintest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+
+	"github.com/kralamoure/retrologin"
+)
+
+// HashParams make the tests quick, and are not meant to be secure.
+var HashParams = &argon2id.Params{
+	Memory:      8,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const (
+	// AccountName is the name of the account of the user "Player".
+	AccountName = "player"
+	// Password is the password of the user "Player".
+	Password = "password123"
+	// GameServerId is the ID of the game server on which the account has a
+	// character.
+	GameServerId = 601
+)
+
+// Server is a login server listening on a loopback address.
+type Server struct {
+	Addr      string
+	Storage   *retrologin.MemoryStorage
+	AccountId string
+}
+
+// Start serves c on a loopback listener until the end of the test, with a memory
+// storage holding the subscribed account AccountName of the user "Player", who
+// has a character on the game server GameServerId at 127.0.0.1:5556.
+func Start(t *testing.T, c retrologin.Config) *Server {
+	t.Helper()
+	ctx := context.Background()
+
+	storage := retrologin.NewMemoryStorage()
+	hash, err := argon2id.CreateHash(Password, HashParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := storage.CreateUser(ctx, dofus.User{
+		Nickname:       "Player",
+		Email:          "player@example.com",
+		Hash:           dofustyp.Hash(hash),
+		SecretQuestion: "question",
+		Community:      dofustyp.CommunityInternational,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountId, err := storage.CreateAccount(ctx, dofus.Account{
+		UserId:       userId,
+		Name:         AccountName,
+		Subscription: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.CreateGameServer(ctx, retro.GameServer{
+		Id:    GameServerId,
+		Host:  "127.0.0.1",
+		Port:  "5556",
+		State: retrotyp.GameServerStateOnline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.CreateCharacter(ctx, retro.Character{
+		AccountId:    accountId,
+		GameServerId: GameServerId,
+		Name:         "Ogre",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Storage = storage
+	c.HashParams = HashParams
+	svr, err := retrologin.NewServer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svr.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return &Server{
+		Addr:      ln.Addr().String(),
+		Storage:   storage,
+		AccountId: accountId,
+	}
+}
@@ -11,122 +11,269 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alexedwards/argon2id"
 	"github.com/happybydefault/logging"
-	"github.com/kralamoure/dofus/dofussvc"
-	"github.com/kralamoure/retro/retrosvc"
 	"github.com/kralamoure/retroproto/msgsvr"
 	"github.com/kralamoure/retroproto/typ"
 	"go.uber.org/atomic"
 )
 
+// keepAliveConn is a connection that supports TCP keep-alives, like a
+// *net.TCPConn.
+type keepAliveConn interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
 type Server struct {
-	logger      logging.Logger
-	addr        *net.TCPAddr
-	connTimeout time.Duration
-	ticketDur   time.Duration
-	dofus       *dofussvc.Service
-	retro       *retrosvc.Service
-
-	mu                 sync.Mutex
-	ln                 *net.TCPListener
-	sessions           map[*session]struct{}
-	sessionByAccountId map[string]*session
-
-	hosts atomic.String
+	logger    logging.Logger
+	listeners []*listener
+	// defaultListener has the settings of the listeners passed to Serve.
+	defaultListener *listener
+	ticketDur       time.Duration
+	storage         Storage
+
+	ipFilter    *ipFilter
+	connLimiter *connLimiter
+	queue       *loginQueue
+	versions    *versionPolicy
+
+	loginAttempts  LoginAttemptStore
+	ipLockout      lockoutPolicy
+	accountLockout lockoutPolicy
+	accountDelay   lockoutPolicy
+	dummyHash      string
+	passwords      *passwordPool
+	hashVerifiers  []HashVerifier
+	hashParams     *argon2id.Params
+	userHashes     UserHashUpdater
+
+	credentialDecoders map[int]CredentialDecoder
+	bans               BanStore
+	takeover           TakeoverPolicy
+	takeoverGrace      time.Duration
+
+	mu                  sync.Mutex
+	sessions            map[*session]struct{}
+	sessionsByAccountId map[string]map[*session]struct{}
+	logouts             map[string]logout
+
+	hosts       atomic.String
+	maintenance atomic.Bool
+
+	// watchMu guards the background watchers, shared by the serve calls running
+	// at the same time.
+	watchMu  sync.Mutex
+	serving  int
+	watchers *watchers
 }
 
-func (s *Server) ListenAndServe(ctx context.Context) error {
-	var wg sync.WaitGroup
-	defer wg.Wait()
+type watchers struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// err is the error that stopped the watchers, if any. It is set before done
+	// is closed.
+	err error
+}
 
-	hosts, err := s.fetchHosts(ctx)
-	if err != nil {
-		return err
+// ListenAndServe listens on the addresses of the configured listeners and serves
+// the clients connecting to them until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	lns := make([]net.Listener, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		ln, err := net.ListenTCP(l.network, l.addr)
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
 	}
-	s.hosts.Store(hosts)
 
-	ln, err := net.ListenTCP("tcp4", s.addr)
+	return s.serve(ctx, s.listeners, lns)
+}
+
+// Serve serves the clients accepted by ln until ctx is done, with the timeouts of
+// Config. It closes ln before returning.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	return s.serve(ctx, []*listener{s.defaultListener}, []net.Listener{ln})
+}
+
+func (s *Server) serve(ctx context.Context, listeners []*listener, lns []net.Listener) error {
+	w, err := s.startWatchers(ctx)
 	if err != nil {
+		for _, ln := range lns {
+			ln.Close()
+		}
 		return err
 	}
-	defer func() {
-		ln.Close()
-		s.logger.Infow("stopped listening",
+	defer s.releaseWatchers()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, ln := range lns {
+		ln := ln
+		defer func() {
+			ln.Close()
+			s.logger.Infow("stopped listening",
+				"address", ln.Addr().String(),
+			)
+		}()
+		s.logger.Infow("listening",
 			"address", ln.Addr().String(),
 		)
-	}()
-	s.logger.Infow("listening",
-		"address", ln.Addr().String(),
-	)
-	s.ln = ln
+	}
 
 	errCh := make(chan error)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := s.watchTickets(ctx, 1*time.Second)
-		if err != nil {
-			select {
-			case errCh <- err:
-			case <-ctx.Done():
-			}
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := s.watchHosts(ctx, 1*time.Second)
-		if err != nil {
-			select {
-			case errCh <- err:
-			case <-ctx.Done():
-			}
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := s.acceptLoop(ctx)
-		if err != nil {
-			select {
-			case errCh <- err:
-			case <-ctx.Done():
+	for i, l := range listeners {
+		l, ln := l, lns[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.acceptLoop(ctx, l, ln)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-ctx.Done():
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	case err := <-errCh:
 		return err
+	case <-w.done:
+		return w.err
 	}
 }
 
-func (s *Server) controlAccount(accountId string, sess *session) error {
+// startWatchers starts the background watchers, unless another serve call
+// already has, and returns them. Each call must be followed by one to
+// releaseWatchers.
+func (s *Server) startWatchers(ctx context.Context) (*watchers, error) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	if s.serving == 0 {
+		hosts, err := s.fetchHosts(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.hosts.Store(hosts)
+
+		watchCtx, cancel := context.WithCancel(context.Background())
+		s.watchers = &watchers{
+			cancel: cancel,
+			done:   make(chan struct{}),
+		}
+		go s.runWatchers(watchCtx, s.watchers)
+	}
+	s.serving++
+
+	return s.watchers, nil
+}
+
+// releaseWatchers stops the background watchers once the last serve call using
+// them is done.
+func (s *Server) releaseWatchers() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+
+	s.serving--
+	if s.serving > 0 {
+		return
+	}
+	s.watchers.cancel()
+	<-s.watchers.done
+	s.watchers = nil
+}
+
+// runWatchers runs the background watchers until ctx is done or one of them
+// fails.
+func (s *Server) runWatchers(ctx context.Context, w *watchers) {
+	defer close(w.done)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var once sync.Once
+	watch := func(f func(ctx context.Context, d time.Duration) error, d time.Duration) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := f(ctx, d)
+			if err != nil && ctx.Err() == nil {
+				once.Do(func() {
+					w.err = err
+					w.cancel()
+				})
+			}
+		}()
+	}
+
+	watch(s.watchTickets, 1*time.Second)
+	watch(s.watchHosts, 1*time.Second)
+	watch(s.watchQueue, 1*time.Second)
+	watch(s.watchPasswordPool, 1*time.Minute)
+	watch(s.watchIPFilter, 1*time.Second)
+	watch(s.watchBans, 10*time.Second)
+	watch(s.watchLogouts, 1*time.Minute)
+}
+
+// SetMaintenance turns the maintenance mode on or off. During a maintenance, only
+// admin accounts can log in. If kickIdle is true, turning it on also disconnects
+// the non-admin sessions that are already logged in.
+func (s *Server) SetMaintenance(on, kickIdle bool) {
+	s.maintenance.Store(on)
+	s.logger.Infow("set maintenance mode",
+		"maintenance", on,
+	)
+
+	if !on || !kickIdle {
+		return
+	}
+
+	var idle []*session
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	for sess := range s.sessions {
+		if sess.status.Load() != statusIdle || sess.admin {
+			continue
+		}
+		idle = append(idle, sess)
+	}
+	s.mu.Unlock()
 
-	currentSess, ok := s.sessionByAccountId[accountId]
-	if ok {
-		currentSess.conn.Close()
-		return errors.New("already logged in")
+	for _, sess := range idle {
+		go sess.disconnect(msgsvr.AksServerMessage{Value: serverMessageShutdown})
 	}
+}
 
-	s.sessionByAccountId[accountId] = sess
+// Maintenance reports whether the maintenance mode is on.
+func (s *Server) Maintenance() bool {
+	return s.maintenance.Load()
+}
 
-	return nil
+// PasswordPoolStats returns the metrics of the pool that bounds the number of
+// password hashes verified concurrently.
+func (s *Server) PasswordPoolStats() PasswordPoolStats {
+	return s.passwords.stats()
 }
 
-func (s *Server) acceptLoop(ctx context.Context) error {
+func (s *Server) acceptLoop(ctx context.Context, l *listener, ln net.Listener) error {
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
 	for {
-		conn, err := s.ln.AcceptTCP()
+		conn, err := ln.Accept()
 		if err != nil {
 			return err
 		}
@@ -134,39 +281,103 @@ func (s *Server) acceptLoop(ctx context.Context) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			err := s.handleClientConn(ctx, conn)
-			if err != nil {
-				if errors.Is(err, os.ErrDeadlineExceeded) ||
-					errors.Is(err, io.EOF) ||
-					errors.Is(err, context.Canceled) ||
-					errors.Is(err, errInvalidRequest) {
-					s.logger.Debugw(fmt.Errorf("error while handling client connection: %w", err).Error(),
-						"client_address", conn.RemoteAddr().String(),
-					)
-				} else {
-					s.logger.Errorw(fmt.Errorf("error while handling client connection: %w", err).Error(),
-						"client_address", conn.RemoteAddr().String(),
-					)
-				}
-			}
+			s.serveConn(ctx, l, conn)
 		}()
 	}
 }
 
-func (s *Server) handleClientConn(ctx context.Context, conn *net.TCPConn) error {
+// serveConn admits a client connection, reading first the PROXY protocol header
+// if it comes from a trusted proxy, and handles it.
+func (s *Server) serveConn(ctx context.Context, l *listener, conn net.Conn) {
+	if l.trustsProxy(addrIP(conn.RemoteAddr())) {
+		pc, err := readProxyHeader(conn)
+		if err != nil {
+			s.logger.Debugw(fmt.Errorf("could not read PROXY protocol header: %w", err).Error(),
+				"proxy_address", conn.RemoteAddr().String(),
+			)
+			conn.Close()
+			return
+		}
+		conn = pc
+	}
+
+	ip := addrIP(conn.RemoteAddr())
+	rule, ok := s.ipFilter.check(ip)
+	if ok && !l.allows(ip) {
+		rule, ok = "listener", false
+	}
+	if !ok {
+		s.logger.Debugw("filtered client connection",
+			"client_address", conn.RemoteAddr().String(),
+			"rule", rule,
+		)
+		conn.Close()
+		return
+	}
+
+	msg, ok := s.connLimiter.admit(ip)
+	if !ok {
+		s.refuseConn(conn, msgsvr.AksServerMessage{Value: msg})
+		return
+	}
+	defer s.connLimiter.release(ip)
+
+	err := s.handleClientConn(ctx, l, conn)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, context.Canceled) ||
+			errors.Is(err, errInvalidRequest) {
+			s.logger.Debugw(fmt.Errorf("error while handling client connection: %w", err).Error(),
+				"client_address", conn.RemoteAddr().String(),
+			)
+		} else {
+			s.logger.Errorw(fmt.Errorf("error while handling client connection: %w", err).Error(),
+				"client_address", conn.RemoteAddr().String(),
+			)
+		}
+	}
+}
+
+// refuseConn sends msg to a client whose connection was not admitted, and closes
+// it.
+func (s *Server) refuseConn(conn net.Conn, msg msgsvr.AksServerMessage) {
+	defer conn.Close()
+
+	s.logger.Infow("refused client connection",
+		"client_address", conn.RemoteAddr().String(),
+		"server_message", msg.Value,
+	)
+
+	pkt, err := msg.Serialized()
+	if err != nil {
+		return
+	}
+	err = conn.SetWriteDeadline(time.Now().UTC().Add(5 * time.Second))
+	if err != nil {
+		return
+	}
+	fmt.Fprint(conn, fmt.Sprint(msg.ProtocolId(), pkt)+"\x00")
+}
+
+func (s *Server) handleClientConn(ctx context.Context, l *listener, conn net.Conn) error {
 	salt, err := randomSalt(32)
 	if err != nil {
 		return err
 	}
 
 	sess := &session{
-		svr:  s,
-		conn: conn,
-		salt: salt,
+		svr:       s,
+		lis:       l,
+		conn:      conn,
+		salt:      salt,
+		queued:    make(chan struct{}),
+		loginTurn: make(chan struct{}),
 	}
 
 	s.trackSession(sess, true)
 	defer s.trackSession(sess, false)
+	defer s.queue.leave(sess)
 
 	var wg sync.WaitGroup
 	defer wg.Wait()
@@ -181,22 +392,22 @@ func (s *Server) handleClientConn(ctx context.Context, conn *net.TCPConn) error
 		"client_address", conn.RemoteAddr().String(),
 	)
 
-	err = conn.SetKeepAlivePeriod(1 * time.Minute)
-	if err != nil {
-		return err
-	}
-	err = conn.SetKeepAlive(true)
-	if err != nil {
-		return err
-	}
-
-	if s.connTimeout > 0 {
-		err = conn.SetReadDeadline(time.Now().UTC().Add(s.connTimeout))
+	if kaConn, ok := conn.(keepAliveConn); ok {
+		err = kaConn.SetKeepAlivePeriod(1 * time.Minute)
+		if err != nil {
+			return err
+		}
+		err = kaConn.SetKeepAlive(true)
 		if err != nil {
 			return err
 		}
 	}
 
+	err = sess.setTimeout(l.loginTimeout)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -214,13 +425,25 @@ func (s *Server) handleClientConn(ctx context.Context, conn *net.TCPConn) error
 		}
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := sess.waitLogin(ctx)
+		if err != nil {
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
 	sess.sendMessage(msgsvr.AksHelloConnect{Salt: sess.salt})
 
 	select {
 	case err := <-errCh:
 		return err
 	case <-ctx.Done():
-		sess.sendMessage(msgsvr.AksServerMessage{Value: "04"})
+		sess.sendMessage(msgsvr.AksServerMessage{Value: serverMessageShutdown})
 
 		return ctx.Err()
 	}
@@ -274,6 +497,56 @@ func (s *Server) watchTickets(ctx context.Context, d time.Duration) error {
 	}
 }
 
+func (s *Server) watchQueue(ctx context.Context, d time.Duration) error {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, update := range s.queue.updates() {
+				update.sess.sendQueuePosition(update.msg)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Server) watchPasswordPool(ctx context.Context, d time.Duration) error {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	var last PasswordPoolStats
+	for {
+		select {
+		case <-ticker.C:
+			stats := s.passwords.stats()
+			if stats.TimedOut > last.TimedOut {
+				s.logger.Warnw("password pool is saturated",
+					"size", stats.Size,
+					"in_use", stats.InUse,
+					"waiting", stats.Waiting,
+					"acquired", stats.Acquired-last.Acquired,
+					"timed_out", stats.TimedOut-last.TimedOut,
+					"wait_time", stats.WaitTime-last.WaitTime,
+				)
+			} else if stats.Acquired > last.Acquired {
+				s.logger.Debugw("password pool stats",
+					"size", stats.Size,
+					"in_use", stats.InUse,
+					"waiting", stats.Waiting,
+					"acquired", stats.Acquired-last.Acquired,
+					"wait_time", stats.WaitTime-last.WaitTime,
+				)
+			}
+			last = stats
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 func (s *Server) sendUpdatedHosts(hosts msgsvr.AccountHosts) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -286,7 +559,7 @@ func (s *Server) sendUpdatedHosts(hosts msgsvr.AccountHosts) {
 }
 
 func (s *Server) fetchHosts(ctx context.Context) (string, error) {
-	gameServers, err := s.retro.GameServers(ctx)
+	gameServers, err := s.storage.GameServers(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -312,7 +585,7 @@ func (s *Server) fetchHosts(ctx context.Context) (string, error) {
 }
 
 func (s *Server) deleteOldTickets(ctx context.Context) (count int, err error) {
-	return s.retro.DeleteTickets(ctx, time.Now().UTC().Add(-s.ticketDur))
+	return s.storage.DeleteTickets(ctx, time.Now().UTC().Add(-s.ticketDur))
 }
 
 func (s *Server) trackSession(sess *session, add bool) {
@@ -322,7 +595,7 @@ func (s *Server) trackSession(sess *session, add bool) {
 	if add {
 		s.sessions[sess] = struct{}{}
 	} else {
-		delete(s.sessionByAccountId, sess.accountId)
+		s.releaseAccount(sess)
 		delete(s.sessions, sess)
 	}
 }
@@ -0,0 +1,66 @@
+package retrologin
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeSharesWatchers(t *testing.T) {
+	svr := newLoginTestServer(t)
+
+	var cancels []context.CancelFunc
+	var dones []chan struct{}
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			svr.Serve(ctx, ln)
+		}()
+		cancels, dones = append(cancels, cancel), append(dones, done)
+	}
+
+	watchers := func() (int, *watchers) {
+		svr.watchMu.Lock()
+		defer svr.watchMu.Unlock()
+		return svr.serving, svr.watchers
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		serving, _ := watchers()
+		if serving == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("serving = %d, want 2", serving)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, w := watchers()
+
+	cancels[0]()
+	<-dones[0]
+	serving, current := watchers()
+	if serving != 1 || current != w {
+		t.Errorf("after the first Serve returned: serving = %d, watchers changed: %t, want 1, false", serving,
+			current != w)
+	}
+	select {
+	case <-w.done:
+		t.Errorf("the watchers stopped while a Serve is still running")
+	default:
+	}
+
+	cancels[1]()
+	<-dones[1]
+	serving, current = watchers()
+	if serving != 0 || current != nil {
+		t.Errorf("after the last Serve returned: serving = %d, watchers = %v, want 0, nil", serving, current)
+	}
+}
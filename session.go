@@ -8,9 +8,10 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/alexedwards/argon2id"
+	"github.com/kralamoure/dofus"
 	"github.com/kralamoure/retroproto"
 	"github.com/kralamoure/retroproto/enum"
 	"github.com/kralamoure/retroproto/msgcli"
@@ -23,21 +24,53 @@ const (
 	statusExpectingAccountVersion uint32 = iota
 	statusExpectingAccountCredential
 	statusExpectingAccountQueuePosition
+	statusWaitingInQueue
+	statusLoggingIn
 	statusIdle
 )
 
+// Values of AksServerMessage: an error ("0") followed by the id of its text in
+// the lang files of the client.
+const (
+	serverMessageInactivity         = "01"
+	serverMessageShutdown           = "04" // Also used for maintenances.
+	serverMessageServerFull         = "012"
+	serverMessageTooManyConnections = "016"
+)
+
+// queryTimeout bounds the storage queries of a login.
+var queryTimeout = 5 * time.Second
+
 var errInvalidRequest = errors.New("invalid request")
 
 type session struct {
 	svr    *Server
-	conn   *net.TCPConn
+	lis    *listener
+	conn   net.Conn
 	salt   string
 	status atomic.Uint32
 
+	queueMu sync.Mutex
+	// queued is closed once the client asked for its queue position, and
+	// loginTurn once the queue granted its login turn.
+	queued        chan struct{}
+	loginTurn     chan struct{}
+	queueDeadline time.Time
+
 	version    msgcli.AccountVersion
 	credential msgcli.AccountCredential
 
 	accountId string
+	admin     bool
+}
+
+// authentication is the account of a session whose password was checked.
+type authentication struct {
+	account dofus.Account
+	user    dofus.User
+	// password is the plain password, kept to replace a legacy hash.
+	password string
+	legacy   bool
 }
 
 type msgOut interface {
@@ -53,7 +86,7 @@ func (s *session) receivePackets(ctx context.Context) error {
 		pkt, err := rd.ReadString('\x00')
 		if err != nil {
 			if errors.Is(err, os.ErrDeadlineExceeded) {
-				s.sendMessage(msgsvr.AksServerMessage{Value: "01"})
+				s.sendMessage(msgsvr.AksServerMessage{Value: timeoutServerMessage(s.status.Load())})
 			}
 			return err
 		}
@@ -66,8 +99,8 @@ func (s *session) receivePackets(ctx context.Context) error {
 			continue
 		}
 
-		if s.svr.connTimeout > 0 {
-			err = s.conn.SetReadDeadline(time.Now().UTC().Add(s.svr.connTimeout))
+		if s.status.Load() == statusIdle {
+			err = s.setTimeout(s.lis.idleTimeout)
 			if err != nil {
 				return err
 			}
@@ -183,7 +216,7 @@ func (s *session) frameMessage(id retroproto.MsgCliId) bool {
 		if id != retroproto.AccountCredential {
 			return false
 		}
-	case statusExpectingAccountQueuePosition:
+	case statusExpectingAccountQueuePosition, statusWaitingInQueue, statusLoggingIn:
 		if id != retroproto.AccountQueuePosition {
 			return false
 		}
@@ -195,71 +228,275 @@ func (s *session) frameMessage(id retroproto.MsgCliId) bool {
 	return true
 }
 
-func (s *session) login(ctx context.Context) error {
-	if s.version.Major != 1 || s.version.Minor < 29 {
+func (s *session) waitLogin(ctx context.Context) error {
+	defer s.svr.queue.leave(s)
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.svr.logger.Errorw("recovered from panic",
+				"recover", r,
+			)
+		}
+	}()
+
+	select {
+	case <-s.queued:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// The password is checked before joining the queue, so that the lane of the
+	// session is picked from an authenticated account, and not from whatever
+	// username was typed.
+	auth, err := s.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.svr.queue.join(s, auth.account.Subscription.After(time.Now()))
+	m, ok := s.svr.queue.status(s)
+	if ok {
+		s.sendQueuePosition(m)
+	}
+
+	select {
+	case <-s.loginTurn:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.queueMu.Lock()
+	s.status.Store(statusLoggingIn)
+	s.queueMu.Unlock()
+
+	err = s.conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	return s.login(ctx, auth)
+}
+
+func (s *session) sendQueuePosition(m msgsvr.AccountNewQueue) {
+	s.queueMu.Lock()
+	defer s.queueMu.Unlock()
+
+	if s.status.Load() != statusWaitingInQueue {
+		return
+	}
+	s.sendMessage(m)
+}
+
+// authenticate checks the version and the credential of the client. The storage
+// queries are bounded by queryTimeout, but not the wait for a password
+// verification: while the password verification pool is busy, the client keeps
+// waiting in the queue state.
+func (s *session) authenticate(ctx context.Context) (authentication, error) {
+	if !s.svr.versions.allows(s.version) {
 		s.sendMessage(msgsvr.AccountLoginError{
 			Reason: enum.AccountLoginErrorReason.BadVersion,
-			Extra:  "^1.29.0",
+			Extra:  s.svr.versions.hint,
 		})
 		versionStr, err := s.version.Serialized()
 		if err != nil {
-			return err
+			return authentication{}, err
 		}
 		s.svr.logger.Debugw("wrong version",
 			"client_address", s.conn.RemoteAddr().String(),
 			"version", versionStr,
 		)
-		return errInvalidRequest
+		return authentication{}, errInvalidRequest
+	}
+
+	ip := clientIP(s.conn.RemoteAddr())
+
+	lookupCtx, cancelLookup := context.WithTimeout(ctx, queryTimeout)
+	defer cancelLookup()
+
+	ban, banned, err := s.svr.ipBan(lookupCtx, addrIP(s.conn.RemoteAddr()))
+	if err != nil {
+		return authentication{}, err
+	}
+	if banned {
+		s.sendMessage(ban.loginError(time.Now()))
+		s.svr.logger.Debugw("banned IP address",
+			"client_address", s.conn.RemoteAddr().String(),
+			"until", ban.Until,
+			"reason", ban.Reason,
+		)
+		return authentication{}, errInvalidRequest
+	}
+
+	lockout, err := s.svr.lockout(lookupCtx, ip, s.credential.Username)
+	if err != nil {
+		return authentication{}, err
+	}
+	if lockout > 0 {
+		s.sendMessage(msgsvr.AccountLoginError{
+			Reason: enum.AccountLoginErrorReason.Kicked,
+			Extra:  remainingTimeExtra(lockout),
+		})
+		s.svr.logger.Debugw("locked out",
+			"client_address", s.conn.RemoteAddr().String(),
+			"remaining", lockout,
+		)
+		return authentication{}, errInvalidRequest
+	}
+
+	delay, err := s.svr.loginDelay(lookupCtx, s.credential.Username)
+	if err != nil {
+		return authentication{}, err
+	}
+	if delay > 0 {
+		s.svr.logger.Debugw("delaying login of account",
+			"client_address", s.conn.RemoteAddr().String(),
+			"delay", delay,
+		)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return authentication{}, ctx.Err()
+		}
 	}
 
-	if s.credential.CryptoMethod != 1 {
+	decoder, ok := s.svr.credentialDecoders[s.credential.CryptoMethod]
+	if !ok {
 		s.svr.logger.Debugw("unhandled crypto method",
 			"client_address", s.conn.RemoteAddr().String(),
 			"crypto_method", s.credential.CryptoMethod,
 		)
-		return errInvalidRequest
+		return authentication{}, errInvalidRequest
 	}
 
-	password, err := decryptedPassword(s.credential.Hash, s.salt)
+	password, err := decoder.DecodePassword(s.credential.Hash, s.salt)
 	if err != nil {
 		s.svr.logger.Debugw("could not decrypt password",
 			"error", err,
 			"client_address", s.conn.RemoteAddr().String(),
 		)
-		return errInvalidRequest
+		return authentication{}, errInvalidRequest
+	}
+
+	// An unknown account goes through the same steps as a wrong password, against
+	// a dummy hash, so that it can't be told apart by timing nor by response.
+	found := true
+	account, err := s.svr.storage.AccountByName(lookupCtx, s.credential.Username)
+	if err != nil {
+		if !errors.Is(err, dofus.ErrNotFound) {
+			return authentication{}, err
+		}
+		found = false
 	}
 
-	account, err := s.svr.dofus.AccountByName(ctx, s.credential.Username)
+	var user dofus.User
+	hash := s.svr.dummyHash
+	if found {
+		user, err = s.svr.storage.User(lookupCtx, account.UserId)
+		if err != nil {
+			return authentication{}, err
+		}
+		hash = string(user.Hash)
+	}
+
+	var match, legacy bool
+	for {
+		match, legacy, err = s.svr.verifyPassword(ctx, password, hash)
+		if !errors.Is(err, errPasswordPoolBusy) {
+			break
+		}
+		s.svr.logger.Debugw("password pool is busy, waiting in the login queue",
+			"client_address", s.conn.RemoteAddr().String(),
+		)
+	}
 	if err != nil {
+		if !errors.Is(err, errUnknownHashFormat) {
+			return authentication{}, err
+		}
 		s.sendMessage(msgsvr.AccountLoginError{
 			Reason: enum.AccountLoginErrorReason.AccessDenied,
 		})
-		if errors.Is(err, retroproto.ErrNotFound) {
-			s.svr.logger.Debugw("could not find account",
-				"error", err,
-				"client_address", s.conn.RemoteAddr().String(),
-			)
-			return nil
-		} else {
-			return err
+		s.svr.logger.Warnw(err.Error(),
+			"client_address", s.conn.RemoteAddr().String(),
+			"user_id", user.Id,
+		)
+		return authentication{}, errInvalidRequest
+	}
+
+	recordCtx, cancelRecord := context.WithTimeout(ctx, queryTimeout)
+	defer cancelRecord()
+
+	if !found || !match {
+		err := s.svr.addFailedLogin(recordCtx, ip, s.credential.Username)
+		if err != nil {
+			return authentication{}, err
 		}
+		s.sendMessage(msgsvr.AccountLoginError{
+			Reason: enum.AccountLoginErrorReason.AccessDenied,
+		})
+		s.svr.logger.Debugw("wrong credentials",
+			"client_address", s.conn.RemoteAddr().String(),
+			"account_found", found,
+		)
+		return authentication{}, errInvalidRequest
 	}
 
-	user, err := s.svr.dofus.User(ctx, account.UserId)
+	err = s.svr.resetFailedLogins(recordCtx, ip, s.credential.Username)
 	if err != nil {
-		return err
+		return authentication{}, err
 	}
 
-	match, err := argon2id.ComparePasswordAndHash(password, string(user.Hash))
+	return authentication{
+		account:  account,
+		user:     user,
+		password: password,
+		legacy:   legacy,
+	}, nil
+}
+
+// login completes the login of an authenticated client, once its login turn was
+// granted.
+func (s *session) login(ctx context.Context, auth authentication) error {
+	account, user := auth.account, auth.user
+
+	ban, banned, err := s.svr.accountBan(ctx, account, user)
 	if err != nil {
 		return err
 	}
+	if banned {
+		s.sendMessage(ban.loginError(time.Now()))
+		s.svr.logger.Debugw("banned account",
+			"client_address", s.conn.RemoteAddr().String(),
+			"account_id", account.Id,
+			"until", ban.Until,
+			"reason", ban.Reason,
+		)
+		return errInvalidRequest
+	}
+
+	if auth.legacy && s.svr.userHashes != nil {
+		err := s.svr.rehashPassword(ctx, user.Id, auth.password)
+		if err != nil {
+			s.svr.logger.Errorw(fmt.Errorf("could not rehash password: %w", err).Error(),
+				"client_address", s.conn.RemoteAddr().String(),
+				"user_id", user.Id,
+			)
+		} else {
+			s.svr.logger.Infow("rehashed legacy password",
+				"user_id", user.Id,
+			)
+		}
+	}
 
-	if !match {
+	if s.svr.maintenance.Load() && !account.Admin {
 		s.sendMessage(msgsvr.AccountLoginError{
-			Reason: enum.AccountLoginErrorReason.AccessDenied,
+			Reason: enum.AccountLoginErrorReason.MaintainAccount,
 		})
-		s.svr.logger.Debugw("wrong password",
+		s.svr.logger.Debugw("refused login during maintenance",
 			"client_address", s.conn.RemoteAddr().String(),
 		)
 		return errInvalidRequest
@@ -276,7 +513,7 @@ func (s *session) login(ctx context.Context) error {
 		)
 		return errInvalidRequest
 	}
-	s.accountId = account.Id
+	s.admin = account.Admin
 
 	s.sendMessage(msgsvr.AccountPseudo{Value: string(user.Nickname)})
 	s.sendMessage(msgsvr.AccountCommunity{Id: int(user.Community)})
@@ -291,10 +528,36 @@ func (s *session) login(ctx context.Context) error {
 
 	s.sendMessage(msgsvr.AccountLoginSuccess{Authorized: account.Admin})
 
+	err = s.setTimeout(s.lis.idleTimeout)
+	if err != nil {
+		return err
+	}
+
 	s.status.Store(statusIdle)
 	return nil
 }
 
+// setTimeout sets the read deadline of the connection to d from now, or removes
+// it if d is zero.
+func (s *session) setTimeout(d time.Duration) error {
+	var t time.Time
+	if d > 0 {
+		t = time.Now().UTC().Add(d)
+	}
+	return s.conn.SetReadDeadline(t)
+}
+
+// timeoutServerMessage returns the server message sent to a client whose session
+// timed out while in status.
+func timeoutServerMessage(status uint32) string {
+	switch status {
+	case statusWaitingInQueue, statusLoggingIn:
+		return serverMessageServerFull
+	default:
+		return serverMessageInactivity
+	}
+}
+
 func (s *session) sendMessage(msg msgOut) {
 	pkt, err := msg.Serialized()
 	if err != nil {
@@ -317,3 +580,11 @@ func (s *session) sendPacket(pkt string) {
 	)
 	fmt.Fprint(s.conn, pkt+"\x00")
 }
+
+// disconnect sends msg to the client, giving up if it isn't written within 5
+// seconds, and closes the connection.
+func (s *session) disconnect(msg msgOut) {
+	s.conn.SetWriteDeadline(time.Now().UTC().Add(5 * time.Second))
+	s.sendMessage(msg)
+	s.conn.Close()
+}
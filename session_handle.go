@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sort"
+	"time"
 
 	"github.com/kralamoure/dofus"
 	"github.com/kralamoure/retro"
@@ -25,23 +26,28 @@ func (s *session) handleAccountCredential(m msgcli.AccountCredential) error {
 }
 
 func (s *session) handleAccountQueuePosition(ctx context.Context) error {
-	s.sendMessage(msgsvr.AccountNewQueue{
-		Position:    1,
-		TotalAbo:    0,
-		TotalNonAbo: 1,
-		Subscriber:  false,
-		QueueId:     0,
-	})
+	if s.status.CAS(statusExpectingAccountQueuePosition, statusWaitingInQueue) {
+		if s.lis.queueTimeout > 0 {
+			s.queueDeadline = time.Now().UTC().Add(s.lis.queueTimeout)
+		}
+		err := s.conn.SetReadDeadline(s.queueDeadline)
+		if err != nil {
+			return err
+		}
+
+		close(s.queued)
+	}
 
-	if s.status.Load() == statusExpectingAccountQueuePosition {
-		return s.login(ctx)
+	m, ok := s.svr.queue.status(s)
+	if ok {
+		s.sendQueuePosition(m)
 	}
 
 	return nil
 }
 
 func (s *session) handleAccountSearchForFriend(ctx context.Context, m msgcli.AccountSearchForFriend) error {
-	user, err := s.svr.dofus.UserByNickname(ctx, m.Pseudo)
+	user, err := s.svr.storage.UserByNickname(ctx, m.Pseudo)
 	if err != nil {
 		if errors.Is(err, dofus.ErrNotFound) {
 			s.sendMessage(msgsvr.AccountFriendServerList{})
@@ -51,7 +57,7 @@ func (s *session) handleAccountSearchForFriend(ctx context.Context, m msgcli.Acc
 		}
 	}
 
-	accounts, err := s.svr.dofus.AccountsByUserId(ctx, user.Id)
+	accounts, err := s.svr.storage.AccountsByUserId(ctx, user.Id)
 	if err != nil {
 		return err
 	}
@@ -59,7 +65,7 @@ func (s *session) handleAccountSearchForFriend(ctx context.Context, m msgcli.Acc
 	serverIdQty := make(map[int]int)
 
 	for _, account := range accounts {
-		characters, err := s.svr.retro.AllCharactersByAccountId(ctx, account.Id)
+		characters, err := s.svr.storage.AllCharactersByAccountId(ctx, account.Id)
 		if err != nil {
 			return err
 		}
@@ -84,14 +90,14 @@ func (s *session) handleAccountSearchForFriend(ctx context.Context, m msgcli.Acc
 }
 
 func (s *session) handleAccountGetServersList(ctx context.Context) error {
-	account, err := s.svr.dofus.Account(ctx, s.accountId)
+	account, err := s.svr.storage.Account(ctx, s.accountId)
 	if err != nil {
 		return err
 	}
 
 	serverIdQty := make(map[int]int)
 
-	characters, err := s.svr.retro.AllCharactersByAccountId(ctx, s.accountId)
+	characters, err := s.svr.storage.AllCharactersByAccountId(ctx, s.accountId)
 	if err != nil {
 		return err
 	}
@@ -118,12 +124,12 @@ func (s *session) handleAccountGetServersList(ctx context.Context) error {
 }
 
 func (s *session) handleAccountSetServer(ctx context.Context, m msgcli.AccountSetServer) error {
-	gameServer, err := s.svr.retro.GameServer(ctx, m.Id)
+	gameServer, err := s.svr.storage.GameServer(ctx, m.Id)
 	if err != nil {
 		return err
 	}
 
-	id, err := s.svr.retro.CreateTicket(ctx, retro.Ticket{
+	id, err := s.svr.storage.CreateTicket(ctx, retro.Ticket{
 		AccountId:    s.accountId,
 		GameServerId: m.Id,
 	})
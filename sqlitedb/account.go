@@ -0,0 +1,89 @@
+package sqlitedb
+
+import (
+	"context"
+
+	"github.com/kralamoure/dofus"
+)
+
+const accountColumns = "id, name, subscription, admin, user_id, last_access, last_ip"
+
+// CreateAccount adds an account of an existing user, with a random ID if it has
+// none.
+func (r *Db) CreateAccount(ctx context.Context, account dofus.Account) (id string, err error) {
+	id = account.Id
+	if id == "" {
+		id, err = newUUID()
+		if err != nil {
+			return
+		}
+	}
+
+	query := "INSERT INTO accounts (" + accountColumns + ")" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?);"
+
+	_, err = r.db.ExecContext(ctx, query,
+		id, account.Name, formatTime(account.Subscription), account.Admin, account.UserId,
+		formatTime(account.LastAccess), account.LastIP)
+	if err != nil {
+		return "", dofusError(err)
+	}
+	return
+}
+
+func (r *Db) AccountsByUserId(ctx context.Context, userId string) (accounts map[string]dofus.Account, err error) {
+	query := "SELECT " + accountColumns +
+		" FROM accounts" +
+		" WHERE user_id = ?;"
+
+	rows, err := r.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	accounts = make(map[string]dofus.Account)
+	for rows.Next() {
+		var account dofus.Account
+		account, err = scanAccount(rows)
+		if err != nil {
+			return
+		}
+		accounts[account.Id] = account
+	}
+	err = rows.Err()
+	return
+}
+
+func (r *Db) Account(ctx context.Context, id string) (dofus.Account, error) {
+	query := "SELECT " + accountColumns +
+		" FROM accounts" +
+		" WHERE id = ?;"
+
+	account, err := scanAccount(r.db.QueryRowContext(ctx, query, id))
+	return account, dofusError(err)
+}
+
+func (r *Db) AccountByName(ctx context.Context, name string) (dofus.Account, error) {
+	query := "SELECT " + accountColumns +
+		" FROM accounts" +
+		" WHERE name = ?;"
+
+	account, err := scanAccount(r.db.QueryRowContext(ctx, query, name))
+	return account, dofusError(err)
+}
+
+func scanAccount(row scanner) (account dofus.Account, err error) {
+	var subscription, lastAccess string
+	err = row.Scan(&account.Id, &account.Name, &subscription, &account.Admin, &account.UserId,
+		&lastAccess, &account.LastIP)
+	if err != nil {
+		return
+	}
+	account.Subscription, err = parseTime(subscription)
+	if err != nil {
+		return
+	}
+	account.LastAccess, err = parseTime(lastAccess)
+	return
+}
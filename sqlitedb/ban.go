@@ -0,0 +1,143 @@
+package sqlitedb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/kralamoure/dofus"
+
+	"github.com/kralamoure/retrologin"
+)
+
+// AccountBan returns the ban of the account in the account_bans table.
+func (r *Db) AccountBan(ctx context.Context, account dofus.Account, user dofus.User) (ban retrologin.Ban, ok bool, err error) {
+	query := "SELECT until, reason" +
+		" FROM account_bans" +
+		" WHERE account_id = ?;"
+
+	ban, err = scanBan(r.db.QueryRowContext(ctx, query, account.Id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		return
+	}
+	ok = true
+	return
+}
+
+func (r *Db) AccountBansSince(ctx context.Context, since time.Time) (bans map[string]retrologin.Ban, err error) {
+	query := "SELECT account_id, until, reason" +
+		" FROM account_bans" +
+		" WHERE created >= ?;"
+
+	rows, err := r.db.QueryContext(ctx, query, formatTime(since))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	bans = make(map[string]retrologin.Ban)
+	for rows.Next() {
+		var accountId string
+		var until sql.NullString
+		var ban retrologin.Ban
+		err = rows.Scan(&accountId, &until, &ban.Reason)
+		if err != nil {
+			return
+		}
+		ban.Until, err = parseUntil(until)
+		if err != nil {
+			return
+		}
+		bans[accountId] = ban
+	}
+	err = rows.Err()
+	return
+}
+
+// IPBan returns the ban of ip in the ip_bans table, where IPv6 addresses are
+// banned by their /64 network, as keyed by retrologin.IPBanKey.
+func (r *Db) IPBan(ctx context.Context, ip string) (ban retrologin.Ban, ok bool, err error) {
+	query := "SELECT until, reason" +
+		" FROM ip_bans" +
+		" WHERE ip = ?;"
+
+	ban, err = scanBan(r.db.QueryRowContext(ctx, query, ip))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		return
+	}
+	ok = true
+	return
+}
+
+// BanAccount bans an account, replacing its previous ban if any.
+func (r *Db) BanAccount(ctx context.Context, accountId string, ban retrologin.Ban) error {
+	query := "INSERT INTO account_bans (account_id, until, reason, created)" +
+		" VALUES (?, ?, ?, ?)" +
+		" ON CONFLICT (account_id) DO UPDATE SET until = excluded.until, reason = excluded.reason," +
+		" created = excluded.created;"
+
+	_, err := r.db.ExecContext(ctx, query, accountId, formatUntil(ban.Until), ban.Reason, formatTime(time.Now()))
+	return err
+}
+
+func (r *Db) UnbanAccount(ctx context.Context, accountId string) error {
+	query := "DELETE FROM account_bans" +
+		" WHERE account_id = ?;"
+
+	_, err := r.db.ExecContext(ctx, query, accountId)
+	return err
+}
+
+// BanIP bans an IPv4 address or, for an IPv6 address, its /64 network, replacing
+// its previous ban if any.
+func (r *Db) BanIP(ctx context.Context, ip net.IP, ban retrologin.Ban) error {
+	query := "INSERT INTO ip_bans (ip, until, reason, created)" +
+		" VALUES (?, ?, ?, ?)" +
+		" ON CONFLICT (ip) DO UPDATE SET until = excluded.until, reason = excluded.reason," +
+		" created = excluded.created;"
+
+	_, err := r.db.ExecContext(ctx, query, retrologin.IPBanKey(ip), formatUntil(ban.Until), ban.Reason,
+		formatTime(time.Now()))
+	return err
+}
+
+func (r *Db) UnbanIP(ctx context.Context, ip net.IP) error {
+	query := "DELETE FROM ip_bans" +
+		" WHERE ip = ?;"
+
+	_, err := r.db.ExecContext(ctx, query, retrologin.IPBanKey(ip))
+	return err
+}
+
+func scanBan(row scanner) (ban retrologin.Ban, err error) {
+	var until sql.NullString
+	err = row.Scan(&until, &ban.Reason)
+	if err != nil {
+		return
+	}
+	ban.Until, err = parseUntil(until)
+	return
+}
+
+// formatUntil stores the zero time of a permanent ban as NULL.
+func formatUntil(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTime(t), Valid: true}
+}
+
+func parseUntil(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	return parseTime(s.String)
+}
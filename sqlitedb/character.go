@@ -0,0 +1,59 @@
+package sqlitedb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/kralamoure/retro"
+)
+
+// Only the columns that the login server needs are stored.
+const characterColumns = "id, name, sex, class_id, account_id, gameserver_id"
+
+// CreateCharacter adds a character, with the next free ID if it has none.
+func (r *Db) CreateCharacter(ctx context.Context, character retro.Character) (id int, err error) {
+	query := "INSERT INTO characters (" + characterColumns + ")" +
+		" VALUES (?, ?, ?, ?, ?, ?);"
+
+	// A NULL id makes SQLite pick the next one.
+	var characterId sql.NullInt64
+	if character.Id != 0 {
+		characterId = sql.NullInt64{Int64: int64(character.Id), Valid: true}
+	}
+
+	res, err := r.db.ExecContext(ctx, query,
+		characterId, character.Name, character.Sex, character.ClassId, character.AccountId, character.GameServerId)
+	if err != nil {
+		return 0, retroError(err)
+	}
+	lastId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(lastId), nil
+}
+
+func (r *Db) AllCharactersByAccountId(ctx context.Context, accountId string) (characters map[int]retro.Character, err error) {
+	query := "SELECT " + characterColumns +
+		" FROM characters" +
+		" WHERE account_id = ?;"
+
+	rows, err := r.db.QueryContext(ctx, query, accountId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	characters = make(map[int]retro.Character)
+	for rows.Next() {
+		var character retro.Character
+		err = rows.Scan(&character.Id, &character.Name, &character.Sex, &character.ClassId, &character.AccountId,
+			&character.GameServerId)
+		if err != nil {
+			return
+		}
+		characters[character.Id] = character
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,81 @@
+package sqlitedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/retro"
+	"github.com/mattn/go-sqlite3"
+)
+
+// uniqueViolation returns the columns of the unique constraint violated by err,
+// as in "users.nickname", if any.
+func uniqueViolation(err error) (string, bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique &&
+		sqliteErr.ExtendedCode != sqlite3.ErrConstraintPrimaryKey {
+		return "", false
+	}
+	return strings.TrimPrefix(sqliteErr.Error(), "UNIQUE constraint failed: "), true
+}
+
+func dofusError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", dofus.ErrNotFound, err)
+	}
+
+	columns, ok := uniqueViolation(err)
+	if !ok {
+		return err
+	}
+
+	var dbErr error
+	switch columns {
+	case "users.email":
+		dbErr = dofus.ErrUserEmailAlreadyExists
+	case "users.nickname":
+		dbErr = dofus.ErrUserNicknameAlreadyExists
+	case "accounts.name":
+		dbErr = dofus.ErrAccountNameAlreadyExists
+	default:
+		dbErr = dofus.ErrAlreadyExists
+	}
+
+	return fmt.Errorf("%w: %s", dbErr, err)
+}
+
+func retroError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s", retro.ErrNotFound, err)
+	}
+
+	columns, ok := uniqueViolation(err)
+	if !ok {
+		return err
+	}
+
+	var dbErr error
+	switch columns {
+	case "gameservers.host, gameservers.port":
+		dbErr = retro.ErrGameServerHostAndPortAlreadyExist
+	case "characters.name, characters.gameserver_id":
+		dbErr = retro.ErrCharacterNameAndGameServerIdAlreadyExist
+	case "tickets.account_id":
+		dbErr = retro.ErrTicketAccountIdAlreadyExists
+	default:
+		dbErr = retro.ErrAlreadyExists
+	}
+
+	return fmt.Errorf("%w: %s", dbErr, err)
+}
@@ -0,0 +1,56 @@
+package sqlitedb
+
+import (
+	"context"
+
+	"github.com/kralamoure/retro"
+)
+
+const gameServerColumns = "id, host, port, state, completion"
+
+func (r *Db) CreateGameServer(ctx context.Context, gameServer retro.GameServer) error {
+	query := "INSERT INTO gameservers (" + gameServerColumns + ")" +
+		" VALUES (?, ?, ?, ?, ?);"
+
+	_, err := r.db.ExecContext(ctx, query,
+		gameServer.Id, gameServer.Host, gameServer.Port, gameServer.State, gameServer.Completion)
+	return retroError(err)
+}
+
+func (r *Db) GameServers(ctx context.Context) (gameServers map[int]retro.GameServer, err error) {
+	query := "SELECT " + gameServerColumns +
+		" FROM gameservers;"
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	gameServers = make(map[int]retro.GameServer)
+	for rows.Next() {
+		var gameServer retro.GameServer
+		gameServer, err = scanGameServer(rows)
+		if err != nil {
+			return
+		}
+		gameServers[gameServer.Id] = gameServer
+	}
+	err = rows.Err()
+	return
+}
+
+func (r *Db) GameServer(ctx context.Context, id int) (retro.GameServer, error) {
+	query := "SELECT " + gameServerColumns +
+		" FROM gameservers" +
+		" WHERE id = ?;"
+
+	gameServer, err := scanGameServer(r.db.QueryRowContext(ctx, query, id))
+	return gameServer, retroError(err)
+}
+
+func scanGameServer(row scanner) (gameServer retro.GameServer, err error) {
+	err = row.Scan(&gameServer.Id, &gameServer.Host, &gameServer.Port, &gameServer.State,
+		&gameServer.Completion)
+	return
+}
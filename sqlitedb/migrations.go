@@ -0,0 +1,111 @@
+package sqlitedb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are the changes of the schema, in order. The version of the schema,
+// kept in the user_version pragma, is the number of migrations applied. Released
+// migrations must never change; new ones are appended.
+var migrations = []string{
+	`CREATE TABLE users (
+		id              TEXT    PRIMARY KEY,
+		email           TEXT    NOT NULL UNIQUE COLLATE NOCASE,
+		nickname        TEXT    NOT NULL UNIQUE COLLATE NOCASE,
+		hash            TEXT    NOT NULL,
+		secret_question TEXT    NOT NULL,
+		secret_answer   TEXT    NOT NULL,
+		gender          INTEGER NOT NULL DEFAULT 0,
+		community       INTEGER NOT NULL DEFAULT 2
+	);
+
+	CREATE TABLE accounts (
+		id           TEXT    PRIMARY KEY,
+		name         TEXT    NOT NULL UNIQUE COLLATE NOCASE,
+		subscription TEXT    NOT NULL DEFAULT '0001-01-01T00:00:00.000000000Z',
+		admin        INTEGER NOT NULL DEFAULT 0,
+		user_id      TEXT    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		last_access  TEXT    NOT NULL DEFAULT '0001-01-01T00:00:00.000000000Z',
+		last_ip      TEXT    NOT NULL DEFAULT ''
+	);
+	CREATE INDEX accounts_user_id_idx ON accounts (user_id);
+
+	CREATE TABLE gameservers (
+		id         INTEGER PRIMARY KEY,
+		host       TEXT    NOT NULL,
+		port       TEXT    NOT NULL,
+		state      INTEGER NOT NULL DEFAULT 0,
+		completion INTEGER NOT NULL DEFAULT 0,
+		UNIQUE (host, port)
+	);
+
+	CREATE TABLE characters (
+		id            INTEGER PRIMARY KEY,
+		name          TEXT    NOT NULL COLLATE NOCASE,
+		sex           INTEGER NOT NULL,
+		class_id      INTEGER NOT NULL,
+		account_id    TEXT    NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+		gameserver_id INTEGER NOT NULL REFERENCES gameservers (id) ON DELETE CASCADE,
+		UNIQUE (name, gameserver_id)
+	);
+	CREATE INDEX characters_account_id_idx ON characters (account_id);
+
+	CREATE TABLE tickets (
+		id            TEXT    PRIMARY KEY,
+		account_id    TEXT    NOT NULL UNIQUE,
+		gameserver_id INTEGER NOT NULL,
+		created       TEXT    NOT NULL
+	);`,
+	`CREATE TABLE account_bans (
+		account_id TEXT PRIMARY KEY REFERENCES accounts (id) ON DELETE CASCADE,
+		until      TEXT,
+		reason     TEXT NOT NULL DEFAULT '',
+		created    TEXT NOT NULL
+	);
+	CREATE INDEX account_bans_created_idx ON account_bans (created);
+
+	CREATE TABLE ip_bans (
+		ip      TEXT PRIMARY KEY,
+		until   TEXT,
+		reason  TEXT NOT NULL DEFAULT '',
+		created TEXT NOT NULL
+	);`,
+}
+
+// Migrate applies the migrations that the database is missing.
+func (r *Db) Migrate(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known, %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		err = migrate(ctx, tx, i+1, migrations[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func migrate(ctx context.Context, tx *sql.Tx, version int, query string) error {
+	_, err := tx.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("could not migrate to schema version %d: %w", version, err)
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d;", version))
+	return err
+}
@@ -0,0 +1,91 @@
+// Package sqlitedb is a SQLite database implementation of the retrologin.Storage
+// interface, for small servers and tests that can do without PostgreSQL.
+package sqlitedb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// timeFormat stores times in UTC with a fixed width, so that they sort as text.
+const timeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// scanner is either a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type Db struct {
+	db *sql.DB
+}
+
+// Open opens the SQLite database at dsn, a file name or a URI as supported by
+// github.com/mattn/go-sqlite3, and migrates its schema to the latest version.
+func Open(ctx context.Context, dsn string) (*Db, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, and in-memory databases are only shared
+	// by the connection that created them.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, "PRAGMA foreign_keys = ON;")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	r, err := NewDb(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = r.Migrate(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func NewDb(db *sql.DB) (*Db, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+
+	return &Db{db: db}, nil
+}
+
+func (r *Db) Close() error {
+	return r.db.Close()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(timeFormat, s)
+}
+
+// newUUID returns a random (version 4) UUID, like the IDs of the PostgreSQL
+// databases.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
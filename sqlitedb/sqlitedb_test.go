@@ -0,0 +1,101 @@
+package sqlitedb_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kralamoure/dofus"
+
+	"github.com/kralamoure/retrologin"
+	"github.com/kralamoure/retrologin/sqlitedb"
+	"github.com/kralamoure/retrologin/storagetest"
+)
+
+func TestDb(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		db, err := sqlitedb.Open(context.Background(), filepath.Join(t.TempDir(), "retrologin.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
+}
+
+func TestOpenMigrated(t *testing.T) {
+	ctx := context.Background()
+	name := filepath.Join(t.TempDir(), "retrologin.db")
+
+	for i := 0; i < 2; i++ {
+		db, err := sqlitedb.Open(ctx, name)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		err = db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBans(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlitedb.Open(ctx, filepath.Join(t.TempDir(), "retrologin.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	userId, err := db.CreateUser(ctx, dofus.User{Email: "player@example.com", Nickname: "Player"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountId, err := db.CreateAccount(ctx, dofus.Account{UserId: userId, Name: "player"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := dofus.Account{Id: accountId, UserId: userId}
+
+	_, ok, err := db.AccountBan(ctx, account, dofus.User{})
+	if err != nil || ok {
+		t.Fatalf("AccountBan of an account not banned = %t, %v", ok, err)
+	}
+
+	since := time.Now()
+	until := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	err = db.BanAccount(ctx, accountId, retrologin.Ban{Until: until, Reason: "cheating"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ban, ok, err := db.AccountBan(ctx, account, dofus.User{})
+	if err != nil || !ok || !ban.Until.Equal(until) || ban.Reason != "cheating" {
+		t.Errorf("AccountBan = %+v, %t, %v, want a ban until %s", ban, ok, err, until)
+	}
+	bans, err := db.AccountBansSince(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bans[accountId]; !ok || len(bans) != 1 {
+		t.Errorf("AccountBansSince = %v, want the ban of %q", bans, accountId)
+	}
+	bans, err = db.AccountBansSince(ctx, time.Now().Add(time.Minute))
+	if err != nil || len(bans) != 0 {
+		t.Errorf("AccountBansSince after the ban = %v, %v, want none", bans, err)
+	}
+
+	err = db.BanIP(ctx, net.ParseIP("2001:db8::1"), retrologin.Ban{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ban, ok, err = db.IPBan(ctx, retrologin.IPBanKey(net.ParseIP("2001:db8::ffff")))
+	if err != nil || !ok || !ban.Until.IsZero() {
+		t.Errorf("IPBan of the same /64 = %+v, %t, %v, want a permanent ban", ban, ok, err)
+	}
+	_, ok, err = db.IPBan(ctx, retrologin.IPBanKey(net.ParseIP("2001:db8:0:1::1")))
+	if err != nil || ok {
+		t.Errorf("IPBan of another /64 = %t, %v, want no ban", ok, err)
+	}
+}
@@ -0,0 +1,102 @@
+package sqlitedb
+
+import (
+	"context"
+	"time"
+
+	"github.com/kralamoure/retro"
+)
+
+const ticketColumns = "id, account_id, gameserver_id, created"
+
+// CreateTicket replaces the ticket of the account, if any, with a new one.
+func (r *Db) CreateTicket(ctx context.Context, ticket retro.Ticket) (id string, err error) {
+	id, err = newUUID()
+	if err != nil {
+		return
+	}
+
+	query := "INSERT INTO tickets (" + ticketColumns + ")" +
+		" VALUES (?, ?, ?, ?)" +
+		" ON CONFLICT (account_id) DO UPDATE SET id = excluded.id, gameserver_id = excluded.gameserver_id," +
+		" created = excluded.created;"
+
+	_, err = r.db.ExecContext(ctx, query, id, ticket.AccountId, ticket.GameServerId, formatTime(time.Now()))
+	if err != nil {
+		return "", retroError(err)
+	}
+	return
+}
+
+func (r *Db) DeleteTickets(ctx context.Context, before time.Time) (count int, err error) {
+	query := "DELETE FROM tickets" +
+		" WHERE created <= ?;"
+
+	res, err := r.db.ExecContext(ctx, query, formatTime(before))
+	if err != nil {
+		return
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *Db) UseTicket(ctx context.Context, id string) (ticket retro.Ticket, err error) {
+	query := "DELETE FROM tickets" +
+		" WHERE id = ?" +
+		" RETURNING " + ticketColumns + ";"
+
+	ticket, err = scanTicket(r.db.QueryRowContext(ctx, query, id))
+	err = retroError(err)
+	return
+}
+
+func (r *Db) DeleteTicketByAccountId(ctx context.Context, accountId string) error {
+	query := "DELETE FROM tickets" +
+		" WHERE account_id = ?;"
+
+	res, err := r.db.ExecContext(ctx, query, accountId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return retro.ErrNotFound
+	}
+	return nil
+}
+
+func (r *Db) Tickets(ctx context.Context) (tickets map[string]retro.Ticket, err error) {
+	query := "SELECT " + ticketColumns +
+		" FROM tickets;"
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	tickets = make(map[string]retro.Ticket)
+	for rows.Next() {
+		var ticket retro.Ticket
+		ticket, err = scanTicket(rows)
+		if err != nil {
+			return
+		}
+		tickets[ticket.Id] = ticket
+	}
+	err = rows.Err()
+	return
+}
+
+func scanTicket(row scanner) (ticket retro.Ticket, err error) {
+	var created string
+	err = row.Scan(&ticket.Id, &ticket.AccountId, &ticket.GameServerId, &created)
+	if err != nil {
+		return
+	}
+	ticket.Created, err = parseTime(created)
+	return
+}
@@ -0,0 +1,76 @@
+package sqlitedb
+
+import (
+	"context"
+
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+)
+
+const userColumns = "id, email, nickname, hash, secret_question, secret_answer, gender, community"
+
+// CreateUser adds a user, with a random ID if it has none.
+func (r *Db) CreateUser(ctx context.Context, user dofus.User) (id string, err error) {
+	id = user.Id
+	if id == "" {
+		id, err = newUUID()
+		if err != nil {
+			return
+		}
+	}
+
+	query := "INSERT INTO users (" + userColumns + ")" +
+		" VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+
+	_, err = r.db.ExecContext(ctx, query,
+		id, user.Email, user.Nickname, user.Hash, user.SecretQuestion, user.SecretAnswer, user.Gender,
+		user.Community)
+	if err != nil {
+		return "", dofusError(err)
+	}
+	return
+}
+
+func (r *Db) User(ctx context.Context, id string) (dofus.User, error) {
+	query := "SELECT " + userColumns +
+		" FROM users" +
+		" WHERE id = ?;"
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
+	return user, dofusError(err)
+}
+
+func (r *Db) UserByNickname(ctx context.Context, nickname string) (dofus.User, error) {
+	query := "SELECT " + userColumns +
+		" FROM users" +
+		" WHERE nickname = ?;"
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, nickname))
+	return user, dofusError(err)
+}
+
+// SetUserHash implements retrologin.UserHashUpdater.
+func (r *Db) SetUserHash(ctx context.Context, id string, hash dofustyp.Hash) error {
+	query := "UPDATE users" +
+		" SET hash = ?" +
+		" WHERE id = ?;"
+
+	res, err := r.db.ExecContext(ctx, query, hash, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return dofus.ErrNotFound
+	}
+	return nil
+}
+
+func scanUser(row scanner) (user dofus.User, err error) {
+	err = row.Scan(&user.Id, &user.Email, &user.Nickname, &user.Hash, &user.SecretQuestion, &user.SecretAnswer,
+		&user.Gender, &user.Community)
+	return
+}
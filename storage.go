@@ -0,0 +1,135 @@
+package retrologin
+
+import (
+	"context"
+	"time"
+
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofussvc"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrosvc"
+)
+
+// AccountStore looks up accounts. Lookups by name are case-insensitive, and
+// missing accounts are reported with dofus.ErrNotFound.
+type AccountStore interface {
+	Account(ctx context.Context, id string) (dofus.Account, error)
+	AccountByName(ctx context.Context, name string) (dofus.Account, error)
+	AccountsByUserId(ctx context.Context, userId string) (map[string]dofus.Account, error)
+}
+
+// UserStore looks up users. Lookups by nickname are case-insensitive, and missing
+// users are reported with dofus.ErrNotFound.
+type UserStore interface {
+	User(ctx context.Context, id string) (dofus.User, error)
+	UserByNickname(ctx context.Context, nickname string) (dofus.User, error)
+}
+
+// CharacterStore looks up the characters of accounts, on every game server.
+type CharacterStore interface {
+	AllCharactersByAccountId(ctx context.Context, accountId string) (map[int]retro.Character, error)
+}
+
+// GameServerStore looks up game servers. Missing game servers are reported with
+// retro.ErrNotFound.
+type GameServerStore interface {
+	GameServers(ctx context.Context) (map[int]retro.GameServer, error)
+	GameServer(ctx context.Context, id int) (retro.GameServer, error)
+}
+
+// TicketStore keeps the tickets that let accounts into game servers. An account
+// has at most one ticket, which creating another one replaces. Missing tickets
+// are reported with retro.ErrNotFound.
+type TicketStore interface {
+	CreateTicket(ctx context.Context, ticket retro.Ticket) (id string, err error)
+	// DeleteTickets deletes the tickets created before or at the given time.
+	DeleteTickets(ctx context.Context, before time.Time) (count int, err error)
+	// DeleteTicketByAccountId deletes the ticket of an account.
+	DeleteTicketByAccountId(ctx context.Context, accountId string) error
+}
+
+// Storage is all of the storage used by Server.
+type Storage interface {
+	AccountStore
+	UserStore
+	CharacterStore
+	GameServerStore
+	TicketStore
+}
+
+// NewServiceStorage returns a Storage backed by the dofus and retro services.
+func NewServiceStorage(dofus *dofussvc.Service, retro *retrosvc.Service) Storage {
+	return serviceStorage{
+		dofus: dofus,
+		retro: retro,
+	}
+}
+
+type serviceStorage struct {
+	dofus *dofussvc.Service
+	retro *retrosvc.Service
+}
+
+func (s serviceStorage) Account(ctx context.Context, id string) (dofus.Account, error) {
+	return s.dofus.Account(ctx, id)
+}
+
+func (s serviceStorage) AccountByName(ctx context.Context, name string) (dofus.Account, error) {
+	return s.dofus.AccountByName(ctx, name)
+}
+
+func (s serviceStorage) AccountsByUserId(ctx context.Context, userId string) (map[string]dofus.Account, error) {
+	return s.dofus.AccountsByUserId(ctx, userId)
+}
+
+func (s serviceStorage) User(ctx context.Context, id string) (dofus.User, error) {
+	return s.dofus.User(ctx, id)
+}
+
+func (s serviceStorage) UserByNickname(ctx context.Context, nickname string) (dofus.User, error) {
+	return s.dofus.UserByNickname(ctx, nickname)
+}
+
+func (s serviceStorage) AllCharactersByAccountId(ctx context.Context, accountId string) (map[int]retro.Character, error) {
+	return s.retro.AllCharactersByAccountId(ctx, accountId)
+}
+
+func (s serviceStorage) GameServers(ctx context.Context) (map[int]retro.GameServer, error) {
+	return s.retro.GameServers(ctx)
+}
+
+func (s serviceStorage) GameServer(ctx context.Context, id int) (retro.GameServer, error) {
+	return s.retro.GameServer(ctx, id)
+}
+
+func (s serviceStorage) CreateTicket(ctx context.Context, ticket retro.Ticket) (string, error) {
+	return s.retro.CreateTicket(ctx, ticket)
+}
+
+func (s serviceStorage) DeleteTickets(ctx context.Context, before time.Time) (int, error) {
+	return s.retro.DeleteTickets(ctx, before)
+}
+
+func (s serviceStorage) UseTicket(ctx context.Context, id string) (retro.Ticket, error) {
+	return s.retro.UseTicket(ctx, id)
+}
+
+// DeleteTicketByAccountId looks the ticket up among all of them, as the retro
+// service can't look tickets up by account.
+func (s serviceStorage) DeleteTicketByAccountId(ctx context.Context, accountId string) error {
+	tickets, err := s.retro.Tickets(ctx)
+	if err != nil {
+		return err
+	}
+	for id, ticket := range tickets {
+		if ticket.AccountId == accountId {
+			_, err := s.retro.UseTicket(ctx, id)
+			return err
+		}
+	}
+	return retro.ErrNotFound
+}
+
+func (s serviceStorage) Tickets(ctx context.Context) (map[string]retro.Ticket, error) {
+	return s.retro.Tickets(ctx)
+}
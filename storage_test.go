@@ -0,0 +1,96 @@
+package retrologin_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofussvc"
+	"github.com/kralamoure/dofuspg"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrosvc"
+	"github.com/kralamoure/retropg"
+
+	"github.com/kralamoure/retrologin"
+	"github.com/kralamoure/retrologin/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return retrologin.NewMemoryStorage()
+	})
+}
+
+// TestServiceStorage runs on the PostgreSQL database of the connection string in
+// RETROLOGIN_TEST_POSTGRES, which must have the schemas of dofuspg and retropg. It
+// leaves rows behind, but no ticket.
+func TestServiceStorage(t *testing.T) {
+	connString := os.Getenv("RETROLOGIN_TEST_POSTGRES")
+	if connString == "" {
+		t.Skip("RETROLOGIN_TEST_POSTGRES is not set")
+	}
+
+	pool, err := pgxpool.Connect(context.Background(), connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	dofusDb, err := dofuspg.NewDb(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retroDb, err := retropg.NewDb(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dofusSvc, err := dofussvc.NewService(dofusDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retroSvc, err := retrosvc.NewService(retrosvc.Config{Storer: retroDb})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		return serviceStorage{
+			Storage: retrologin.NewServiceStorage(dofusSvc, retroSvc),
+			dofus:   dofusSvc,
+			retro:   retroSvc,
+		}
+	})
+}
+
+// serviceStorage adds the methods filling the storage of the services.
+type serviceStorage struct {
+	retrologin.Storage
+	dofus *dofussvc.Service
+	retro *retrosvc.Service
+}
+
+func (s serviceStorage) CreateUser(ctx context.Context, user dofus.User) (string, error) {
+	return s.dofus.CreateUser(ctx, user)
+}
+
+func (s serviceStorage) CreateAccount(ctx context.Context, account dofus.Account) (string, error) {
+	return s.dofus.CreateAccount(ctx, account)
+}
+
+func (s serviceStorage) CreateGameServer(ctx context.Context, gameServer retro.GameServer) error {
+	return s.retro.CreateGameServer(ctx, gameServer)
+}
+
+func (s serviceStorage) CreateCharacter(ctx context.Context, character retro.Character) (int, error) {
+	return s.retro.CreateCharacter(ctx, character)
+}
+
+func (s serviceStorage) UseTicket(ctx context.Context, id string) (retro.Ticket, error) {
+	return s.retro.UseTicket(ctx, id)
+}
+
+func (s serviceStorage) Tickets(ctx context.Context) (map[string]retro.Ticket, error) {
+	return s.retro.Tickets(ctx)
+}
@@ -0,0 +1,523 @@
+// Package storagetest checks that implementations of retrologin.Storage behave
+// like the PostgreSQL one, which is the reference.
+package storagetest
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/kralamoure/dofus"
+	"github.com/kralamoure/dofus/dofustyp"
+	"github.com/kralamoure/retro"
+	"github.com/kralamoure/retro/retrotyp"
+
+	"github.com/kralamoure/retrologin"
+	"github.com/kralamoure/retrologin/retrologintest"
+)
+
+// missingId is a valid UUID that no user, account or ticket has.
+const missingId = "00000000-0000-4000-8000-000000000000"
+
+// Storage is a retrologin.Storage that can be filled, and whose tickets can be
+// listed and used as game servers do. Implementations may ignore the IDs of the
+// users and accounts they create, as PostgreSQL does. If it also implements
+// retrologin.UserHashUpdater, that is tested too.
+type Storage interface {
+	retrologin.Storage
+	CreateUser(ctx context.Context, user dofus.User) (string, error)
+	CreateAccount(ctx context.Context, account dofus.Account) (string, error)
+	CreateGameServer(ctx context.Context, gameServer retro.GameServer) error
+	CreateCharacter(ctx context.Context, character retro.Character) (int, error)
+	// UseTicket deletes a ticket and returns it.
+	UseTicket(ctx context.Context, id string) (retro.Ticket, error)
+	Tickets(ctx context.Context) (map[string]retro.Ticket, error)
+}
+
+// Run runs the tests on storages returned by open, which is called once per test.
+// Names are made unique for each run, and only the tickets of the tests are
+// deleted, so the storages may be shared by runs.
+func Run(t *testing.T, open func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Storage)
+	}{
+		{"Users", testUsers},
+		{"Accounts", testAccounts},
+		{"UserHash", testUserHash},
+		{"GameServers", testGameServers},
+		{"Characters", testCharacters},
+		{"Tickets", testTickets},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, open(t))
+		})
+	}
+}
+
+func testUsers(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := newUser(t)
+
+	id, err := s.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.User(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != id || got.Nickname != user.Nickname || got.Email != user.Email || got.Hash != user.Hash ||
+		got.Community != user.Community {
+		t.Errorf("User = %+v, want %+v with id %q", got, user, id)
+	}
+
+	got, err = s.UserByNickname(ctx, strings.ToUpper(string(user.Nickname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != id {
+		t.Errorf("UserByNickname id = %q, want %q", got.Id, id)
+	}
+
+	_, err = s.User(ctx, missingId)
+	if !errors.Is(err, dofus.ErrNotFound) {
+		t.Errorf("User of a missing id: err = %v, want %v", err, dofus.ErrNotFound)
+	}
+	_, err = s.UserByNickname(ctx, "missing"+uniqueName(t))
+	if !errors.Is(err, dofus.ErrNotFound) {
+		t.Errorf("UserByNickname of a missing nickname: err = %v, want %v", err, dofus.ErrNotFound)
+	}
+
+	other := newUser(t)
+	other.Nickname = dofustyp.Nickname(strings.ToUpper(string(user.Nickname)))
+	_, err = s.CreateUser(ctx, other)
+	if !errors.Is(err, dofus.ErrUserNicknameAlreadyExists) {
+		t.Errorf("CreateUser with a taken nickname: err = %v, want %v", err, dofus.ErrUserNicknameAlreadyExists)
+	}
+
+	other = newUser(t)
+	other.Email = dofustyp.Email(strings.ToUpper(string(user.Email)))
+	_, err = s.CreateUser(ctx, other)
+	if !errors.Is(err, dofus.ErrUserEmailAlreadyExists) {
+		t.Errorf("CreateUser with a taken email: err = %v, want %v", err, dofus.ErrUserEmailAlreadyExists)
+	}
+}
+
+func testAccounts(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userId := createUser(t, s)
+
+	subscription := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	account := dofus.Account{
+		UserId:       userId,
+		Name:         dofustyp.AccountName(uniqueName(t)),
+		Subscription: subscription,
+		Admin:        true,
+	}
+	id, err := s.CreateAccount(ctx, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherId := createAccount(t, s, userId)
+
+	got, err := s.Account(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != id || got.UserId != userId || got.Name != account.Name || !got.Admin ||
+		!got.Subscription.Equal(subscription) {
+		t.Errorf("Account = %+v, want %+v with id %q", got, account, id)
+	}
+
+	got, err = s.AccountByName(ctx, strings.ToUpper(string(account.Name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != id {
+		t.Errorf("AccountByName id = %q, want %q", got.Id, id)
+	}
+
+	accounts, err := s.AccountsByUserId(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[id].Id != id || accounts[otherId].Id != otherId {
+		t.Errorf("AccountsByUserId = %+v, want accounts %q and %q", accounts, id, otherId)
+	}
+
+	_, err = s.Account(ctx, missingId)
+	if !errors.Is(err, dofus.ErrNotFound) {
+		t.Errorf("Account of a missing id: err = %v, want %v", err, dofus.ErrNotFound)
+	}
+	_, err = s.AccountByName(ctx, "missing"+uniqueName(t))
+	if !errors.Is(err, dofus.ErrNotFound) {
+		t.Errorf("AccountByName of a missing name: err = %v, want %v", err, dofus.ErrNotFound)
+	}
+
+	_, err = s.CreateAccount(ctx, dofus.Account{
+		UserId: userId,
+		Name:   dofustyp.AccountName(strings.ToUpper(string(account.Name))),
+	})
+	if !errors.Is(err, dofus.ErrAccountNameAlreadyExists) {
+		t.Errorf("CreateAccount with a taken name: err = %v, want %v", err, dofus.ErrAccountNameAlreadyExists)
+	}
+}
+
+func testUserHash(t *testing.T, s Storage) {
+	updater, ok := s.(retrologin.UserHashUpdater)
+	if !ok {
+		t.Skip("storage does not implement retrologin.UserHashUpdater")
+	}
+
+	ctx := context.Background()
+	id := createUser(t, s)
+	hash := newHash(t)
+
+	err := updater.SetUserHash(ctx, id, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.User(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Hash != hash {
+		t.Errorf("hash = %q, want %q", user.Hash, hash)
+	}
+
+	err = updater.SetUserHash(ctx, missingId, hash)
+	if !errors.Is(err, dofus.ErrNotFound) {
+		t.Errorf("SetUserHash of a missing user: err = %v, want %v", err, dofus.ErrNotFound)
+	}
+}
+
+func testGameServers(t *testing.T, s Storage) {
+	ctx := context.Background()
+	gameServer := newGameServer(t)
+	gameServer.State = retrotyp.GameServerStateStarting
+	gameServer.Completion = 2
+
+	err := s.CreateGameServer(ctx, gameServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GameServer(ctx, gameServer.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != gameServer {
+		t.Errorf("GameServer = %+v, want %+v", got, gameServer)
+	}
+
+	gameServers, err := s.GameServers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gameServers[gameServer.Id] != gameServer {
+		t.Errorf("GameServers[%d] = %+v, want %+v", gameServer.Id, gameServers[gameServer.Id], gameServer)
+	}
+
+	_, err = s.GameServer(ctx, -1)
+	if !errors.Is(err, retro.ErrNotFound) {
+		t.Errorf("GameServer of a missing id: err = %v, want %v", err, retro.ErrNotFound)
+	}
+
+	other := newGameServer(t)
+	other.Host, other.Port = gameServer.Host, gameServer.Port
+	err = s.CreateGameServer(ctx, other)
+	if !errors.Is(err, retro.ErrGameServerHostAndPortAlreadyExist) {
+		t.Errorf("CreateGameServer with a taken address: err = %v, want %v", err,
+			retro.ErrGameServerHostAndPortAlreadyExist)
+	}
+}
+
+func testCharacters(t *testing.T, s Storage) {
+	ctx := context.Background()
+	accountId := createAccount(t, s, createUser(t, s))
+	gameServerId := createGameServer(t, s)
+	otherGameServerId := createGameServer(t, s)
+
+	character := newCharacter(t, accountId, gameServerId)
+	id, err := s.CreateCharacter(ctx, character)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same name is free on another game server.
+	other := character
+	other.GameServerId = otherGameServerId
+	otherId, err := s.CreateCharacter(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == otherId {
+		t.Fatalf("both characters have id %d", id)
+	}
+
+	characters, err := s.AllCharactersByAccountId(ctx, accountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(characters) != 2 {
+		t.Fatalf("AllCharactersByAccountId = %+v, want characters %d and %d", characters, id, otherId)
+	}
+	got := characters[id]
+	if got.Id != id || got.AccountId != accountId || got.GameServerId != gameServerId || got.Name != character.Name ||
+		got.ClassId != character.ClassId || got.Sex != character.Sex {
+		t.Errorf("character = %+v, want %+v with id %d", got, character, id)
+	}
+	if characters[otherId].GameServerId != otherGameServerId {
+		t.Errorf("game server of character %d = %d, want %d", otherId, characters[otherId].GameServerId,
+			otherGameServerId)
+	}
+
+	characters, err = s.AllCharactersByAccountId(ctx, missingId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(characters) != 0 {
+		t.Errorf("AllCharactersByAccountId of a missing account = %+v, want none", characters)
+	}
+
+	taken := newCharacter(t, accountId, gameServerId)
+	taken.Name = character.Name
+	_, err = s.CreateCharacter(ctx, taken)
+	if !errors.Is(err, retro.ErrCharacterNameAndGameServerIdAlreadyExist) {
+		t.Errorf("CreateCharacter with a taken name: err = %v, want %v", err,
+			retro.ErrCharacterNameAndGameServerIdAlreadyExist)
+	}
+}
+
+func testTickets(t *testing.T, s Storage) {
+	ctx := context.Background()
+	accountId := createAccount(t, s, createUser(t, s))
+	gameServerId := createGameServer(t, s)
+	otherGameServerId := createGameServer(t, s)
+
+	id, err := s.CreateTicket(ctx, retro.Ticket{AccountId: accountId, GameServerId: gameServerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tickets, err := s.Tickets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := tickets[id]
+	if !ok || got.Id != id || got.AccountId != accountId || got.GameServerId != gameServerId || got.Created.IsZero() {
+		t.Errorf("Tickets[%q] = %+v, %t, want a ticket of account %q to game server %d", id, got, ok, accountId,
+			gameServerId)
+	}
+
+	// A new ticket of the account replaces the previous one.
+	newId, err := s.CreateTicket(ctx, retro.Ticket{AccountId: accountId, GameServerId: otherGameServerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newId == id {
+		t.Errorf("the new ticket has the id %q of the previous one", id)
+	}
+	tickets, err = s.Tickets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ticket := range tickets {
+		if ticket.AccountId == accountId && ticket.Id != newId {
+			t.Errorf("ticket %q of the account was not replaced", ticket.Id)
+		}
+	}
+
+	got, err = s.UseTicket(ctx, newId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != newId || got.AccountId != accountId || got.GameServerId != otherGameServerId {
+		t.Errorf("UseTicket = %+v, want ticket %q of account %q to game server %d", got, newId, accountId,
+			otherGameServerId)
+	}
+	_, err = s.UseTicket(ctx, newId)
+	if !errors.Is(err, retro.ErrNotFound) {
+		t.Errorf("UseTicket of a used ticket: err = %v, want %v", err, retro.ErrNotFound)
+	}
+	_, err = s.UseTicket(ctx, id)
+	if !errors.Is(err, retro.ErrNotFound) {
+		t.Errorf("UseTicket of a replaced ticket: err = %v, want %v", err, retro.ErrNotFound)
+	}
+
+	id, err = s.CreateTicket(ctx, retro.Ticket{AccountId: accountId, GameServerId: gameServerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.DeleteTicketByAccountId(ctx, accountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.UseTicket(ctx, id)
+	if !errors.Is(err, retro.ErrNotFound) {
+		t.Errorf("UseTicket of a ticket deleted by account: err = %v, want %v", err, retro.ErrNotFound)
+	}
+	err = s.DeleteTicketByAccountId(ctx, accountId)
+	if !errors.Is(err, retro.ErrNotFound) {
+		t.Errorf("DeleteTicketByAccountId without a ticket: err = %v, want %v", err, retro.ErrNotFound)
+	}
+
+	id, err = s.CreateTicket(ctx, retro.Ticket{AccountId: accountId, GameServerId: gameServerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks of database servers may drift a bit from ours.
+	_, err = s.DeleteTickets(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tickets, err = s.Tickets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tickets[id]; !ok {
+		t.Errorf("DeleteTickets deleted the ticket created after the given time")
+	}
+
+	// Only the ticket of the test is deleted, as others may share the storage.
+	created := tickets[id].Created
+	for _, ticket := range tickets {
+		if ticket.Id != id && !ticket.Created.After(created) {
+			s.UseTicket(ctx, id)
+			t.Skipf("ticket %q of another test was created before %q, which can't be deleted alone", ticket.Id,
+				id)
+		}
+	}
+	count, err := s.DeleteTickets(ctx, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("DeleteTickets count = %d, want 1", count)
+	}
+	tickets, err = s.Tickets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tickets[id]; ok {
+		t.Errorf("DeleteTickets did not delete the ticket created before the given time")
+	}
+}
+
+func newUser(t *testing.T) dofus.User {
+	name := uniqueName(t)
+	return dofus.User{
+		Email:          dofustyp.Email(name + "@example.com"),
+		Nickname:       dofustyp.Nickname(name),
+		Hash:           newHash(t),
+		SecretQuestion: "question",
+		SecretAnswer:   "answer",
+		Community:      dofustyp.CommunityInternational,
+	}
+}
+
+func createUser(t *testing.T, s Storage) string {
+	t.Helper()
+
+	id, err := s.CreateUser(context.Background(), newUser(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func createAccount(t *testing.T, s Storage, userId string) string {
+	t.Helper()
+
+	id, err := s.CreateAccount(context.Background(), dofus.Account{
+		UserId: userId,
+		Name:   dofustyp.AccountName(uniqueName(t)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func newGameServer(t *testing.T) retro.GameServer {
+	id := 1000 + randomInt(t, 1_000_000)
+	return retro.GameServer{
+		Id:    id,
+		Host:  "127.0.0.1",
+		Port:  strconv.Itoa(id),
+		State: retrotyp.GameServerStateOnline,
+	}
+}
+
+func createGameServer(t *testing.T, s Storage) int {
+	t.Helper()
+
+	gameServer := newGameServer(t)
+	err := s.CreateGameServer(context.Background(), gameServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gameServer.Id
+}
+
+func newCharacter(t *testing.T, accountId string, gameServerId int) retro.Character {
+	name := uniqueName(t)
+	return retro.Character{
+		AccountId:    accountId,
+		GameServerId: gameServerId,
+		Name:         retrotyp.CharacterName(strings.ToUpper(name[:1]) + name[1:]),
+		Sex:          retrotyp.SexFemale,
+		ClassId:      retrotyp.ClassIdEniripsa,
+		Alignment:    retrotyp.AlignmentNeutral,
+		// A map of the static data of PostgreSQL, which characters reference.
+		GameMapId: 7411,
+		Cell:      311,
+	}
+}
+
+// newHash returns a valid argon2id hash, made quickly.
+func newHash(t *testing.T) dofustyp.Hash {
+	t.Helper()
+
+	hash, err := argon2id.CreateHash(uniqueName(t), retrologintest.HashParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dofustyp.Hash(hash)
+}
+
+// uniqueName returns a random name that is a valid nickname, account name and,
+// capitalized, character name.
+func uniqueName(t *testing.T) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+
+	b := make([]byte, 12)
+	for i := range b {
+		for {
+			b[i] = letters[randomInt(t, len(letters))]
+			// Character names may not repeat a letter more than twice in a row.
+			if i < 2 || b[i] != b[i-1] || b[i] != b[i-2] {
+				break
+			}
+		}
+	}
+	return string(b)
+}
+
+func randomInt(t *testing.T, max int) int {
+	t.Helper()
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(n.Int64())
+}
@@ -0,0 +1,141 @@
+package retrologin
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kralamoure/retroproto/enum"
+	"github.com/kralamoure/retroproto/msgsvr"
+)
+
+var errAlreadyLogged = errors.New("already logged in")
+
+// TakeoverPolicy decides what happens when an account logs in while it is
+// already logged in.
+type TakeoverPolicy int
+
+const (
+	// TakeoverKick disconnects the current session and lets the new one in.
+	TakeoverKick TakeoverPolicy = iota
+	// TakeoverReject refuses the new session.
+	TakeoverReject
+	// TakeoverCoexist lets both sessions in.
+	TakeoverCoexist
+)
+
+// logout is the last disconnection of a logged in account.
+type logout struct {
+	at            time.Time
+	clientAddress string
+}
+
+// controlAccount registers sess as logged in to the account, applying the
+// takeover policy if the account is already logged in, or was within the grace
+// window.
+func (s *Server) controlAccount(accountId string, sess *session) error {
+	// Kicked sessions are disconnected once s.mu is released, so that a client
+	// that doesn't read doesn't hold it.
+	var kicked []*session
+	defer func() {
+		for _, old := range kicked {
+			old.disconnect(msgsvr.AccountLoginError{
+				Reason: enum.AccountLoginErrorReason.AlreadyLogged,
+			})
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.sessionsByAccountId[accountId]
+	last, recent := s.logouts[accountId]
+	if recent && time.Since(last.at) >= s.takeoverGrace {
+		delete(s.logouts, accountId)
+		recent = false
+	}
+
+	if len(current) > 0 || recent {
+		oldAddrs := make([]string, 0, len(current)+1)
+		for old := range current {
+			oldAddrs = append(oldAddrs, old.conn.RemoteAddr().String())
+		}
+		if len(current) == 0 {
+			oldAddrs = append(oldAddrs, last.clientAddress)
+		}
+
+		switch s.takeover {
+		case TakeoverReject:
+			s.logger.Infow("rejected account takeover",
+				"account_id", accountId,
+				"client_address", sess.conn.RemoteAddr().String(),
+				"current_client_addresses", oldAddrs,
+			)
+			return errAlreadyLogged
+		case TakeoverKick:
+			for old := range current {
+				kicked = append(kicked, old)
+				delete(current, old)
+			}
+		}
+		s.logger.Infow("account taken over",
+			"account_id", accountId,
+			"client_address", sess.conn.RemoteAddr().String(),
+			"previous_client_addresses", oldAddrs,
+		)
+	}
+
+	if current == nil {
+		current = make(map[*session]struct{})
+		s.sessionsByAccountId[accountId] = current
+	}
+	current[sess] = struct{}{}
+	delete(s.logouts, accountId)
+	sess.accountId = accountId
+
+	return nil
+}
+
+// releaseAccount unregisters sess from its account, if it was logged in to one.
+// It must be called with s.mu held.
+func (s *Server) releaseAccount(sess *session) {
+	current, ok := s.sessionsByAccountId[sess.accountId]
+	if !ok {
+		return
+	}
+	if _, ok := current[sess]; !ok {
+		return
+	}
+	delete(current, sess)
+	if len(current) > 0 {
+		return
+	}
+	delete(s.sessionsByAccountId, sess.accountId)
+
+	if s.takeoverGrace > 0 && s.takeover != TakeoverCoexist {
+		s.logouts[sess.accountId] = logout{
+			at:            time.Now(),
+			clientAddress: sess.conn.RemoteAddr().String(),
+		}
+	}
+}
+
+func (s *Server) watchLogouts(ctx context.Context, d time.Duration) error {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			for accountId, last := range s.logouts {
+				if time.Since(last.at) >= s.takeoverGrace {
+					delete(s.logouts, accountId)
+				}
+			}
+			s.mu.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
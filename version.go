@@ -0,0 +1,81 @@
+package retrologin
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/kralamoure/retroproto/msgcli"
+)
+
+// DefaultClientVersions is the default constraint on the version of the clients
+// allowed to log in.
+const DefaultClientVersions = "^1.29.0"
+
+type versionPolicy struct {
+	constraints *semver.Constraints
+	hint        string
+	// deniedReleases are the denied major.minor.patch versions, of any build.
+	deniedReleases map[[3]int]struct{}
+	// deniedBuilds are the denied builds with a beta, streaming or electron
+	// suffix.
+	deniedBuilds map[string]struct{}
+}
+
+func newVersionPolicy(constraint string, denied []string) (*versionPolicy, error) {
+	if constraint == "" {
+		constraint = DefaultClientVersions
+	}
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client version constraint: %w", err)
+	}
+
+	p := &versionPolicy{
+		constraints:    constraints,
+		hint:           constraints.String(),
+		deniedReleases: make(map[[3]int]struct{}),
+		deniedBuilds:   make(map[string]struct{}),
+	}
+
+	for _, v := range denied {
+		var version msgcli.AccountVersion
+		err := version.Deserialize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied client version %q: %w", v, err)
+		}
+		if version.Beta == 0 && !version.Streaming && !version.Electron {
+			p.deniedReleases[release(version)] = struct{}{}
+			continue
+		}
+		build, err := version.Serialized()
+		if err != nil {
+			return nil, err
+		}
+		p.deniedBuilds[build] = struct{}{}
+	}
+
+	return p, nil
+}
+
+func (p *versionPolicy) allows(version msgcli.AccountVersion) bool {
+	if version.Major < 0 || version.Minor < 0 || version.Patch < 0 {
+		return false
+	}
+
+	if _, ok := p.deniedReleases[release(version)]; ok {
+		return false
+	}
+	build, err := version.Serialized()
+	if err != nil {
+		return false
+	}
+	if _, ok := p.deniedBuilds[build]; ok {
+		return false
+	}
+
+	return p.constraints.Check(semver.New(uint64(version.Major), uint64(version.Minor), uint64(version.Patch), "", ""))
+}
+
+func release(version msgcli.AccountVersion) [3]int {
+	return [3]int{version.Major, version.Minor, version.Patch}
+}
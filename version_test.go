@@ -0,0 +1,39 @@
+package retrologin
+
+import (
+	"testing"
+
+	"github.com/kralamoure/retroproto/msgcli"
+)
+
+func TestVersionPolicyDenied(t *testing.T) {
+	p, err := newVersionPolicy("", []string{"1.29.1", "1.30.0e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version string
+		allowed bool
+	}{
+		{"1.29.0", true},
+		{"1.29.1", false},
+		{"1.29.1e", false},
+		{"1.29.1s", false},
+		{"1.29.1.2", false},
+		{"1.30.0", true},
+		{"1.30.0e", false},
+		{"1.30.0s", true},
+	}
+
+	for _, test := range tests {
+		var version msgcli.AccountVersion
+		err := version.Deserialize(test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := p.allows(version); allowed != test.allowed {
+			t.Errorf("allows(%s) = %t, want %t", test.version, allowed, test.allowed)
+		}
+	}
+}